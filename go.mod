@@ -3,8 +3,10 @@ module github.com/xychen2001/d7024e-distributed-systems-team8
 go 1.23.5
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.9.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
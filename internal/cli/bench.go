@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/bench"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/node"
+)
+
+var benchBootstrap []string
+var benchListen string
+var benchOperations []string
+var benchCount int
+var benchConcurrency int
+var benchValueSize int
+var benchK int
+var benchAlpha int
+var benchDisjointPaths int
+
+func init() {
+	defaults := node.DefaultConfig()
+	benchCmd.Flags().StringSliceVarP(&benchBootstrap, "bootstrap", "b", nil, "Addresses of cluster nodes to join through")
+	benchCmd.Flags().StringVar(&benchListen, "listen", "127.0.0.1:0", "UDP address of the benchmarking node")
+	benchCmd.Flags().StringSliceVar(&benchOperations, "ops", []string{bench.OpPut, bench.OpGet, bench.OpLookup}, "Operations to run: put, get and lookup")
+	benchCmd.Flags().IntVar(&benchCount, "count", 100, "Number of operations of each kind")
+	benchCmd.Flags().IntVar(&benchConcurrency, "concurrency", 10, "Number of operations in flight at once")
+	benchCmd.Flags().IntVar(&benchValueSize, "value-size", 1024, "Size in bytes of the values written by puts")
+	benchCmd.Flags().IntVarP(&benchK, "k", "k", defaults.K, "Number of contacts returned by lookups and stored to by puts")
+	benchCmd.Flags().IntVar(&benchAlpha, "alpha", defaults.Alpha, "Number of parallel requests per lookup round")
+	benchCmd.Flags().IntVar(&benchDisjointPaths, "disjoint-paths", defaults.DisjointPaths, "Number of disjoint paths lookups run over (1 for plain Kademlia)")
+	benchCmd.MarkFlagRequired("bootstrap")
+	rootCmd.AddCommand(benchCmd)
+}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Benchmarks lookups, puts and gets against a running cluster",
+	Long: `Joins a running cluster as a temporary node and runs concurrent puts, gets and lookups through it.
+For each kind of operation it prints the throughput, success rate, latency percentiles and the
+mean number of lookup rounds (hops) and requests per operation. Gets read back the values written
+by the puts and always go to the network.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := node.DefaultConfig()
+		config.Listen = benchListen
+		config.K = benchK
+		config.Alpha = benchAlpha
+		config.DisjointPaths = benchDisjointPaths
+		config.API.Listen = ""
+
+		n, err := node.New(config)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to start benchmarking node")
+		}
+		defer n.Close()
+		if err := n.Bootstrap(benchBootstrap); err != nil {
+			log.WithError(err).Fatal("Failed to join the cluster")
+		}
+
+		runner := bench.NewRunner(n.Kademlia.RoutingTable, n.Network, config.DHT())
+		reports, err := runner.Run(bench.Options{
+			Operations:  benchOperations,
+			Count:       benchCount,
+			Concurrency: benchConcurrency,
+			ValueSize:   benchValueSize,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("Benchmark failed")
+		}
+
+		fmt.Printf("%-7s %6s %9s %8s %9s %9s %9s %9s %6s %6s\n", "OP", "COUNT", "OPS/S", "SUCCESS", "P50", "P90", "P99", "MAX", "HOPS", "RPCS")
+		for _, report := range reports {
+			fmt.Printf("%-7s %6d %9.1f %7.1f%% %9s %9s %9s %9s %6.2f %6.1f\n",
+				report.Operation, report.Count, report.Throughput(), 100*report.SuccessRate(),
+				round(report.P50), round(report.P90), round(report.P99), round(report.Max),
+				report.Hops, report.RPCs)
+		}
+	},
+}
+
+// round shortens a latency for display
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/api"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/node"
+)
+
+var clusterNodes int
+var clusterHost string
+var clusterBasePort int
+var clusterAPIBasePort int
+var clusterTopology string
+var clusterSmoke bool
+
+func init() {
+	clusterCmd.Flags().IntVarP(&clusterNodes, "nodes", "N", 5, "Number of nodes to start")
+	clusterCmd.Flags().StringVar(&clusterHost, "host", "127.0.0.1", "Host the nodes listen on")
+	clusterCmd.Flags().IntVar(&clusterBasePort, "base-port", 8100, "UDP port of the first node, the others use the following ports")
+	clusterCmd.Flags().IntVar(&clusterAPIBasePort, "api-base-port", 9100, "Control API port of the first node (0 disables the API)")
+	clusterCmd.Flags().StringVar(&clusterTopology, "topology", "star", "How the nodes bootstrap: star (all through the first node) or chain (each through the previous one)")
+	clusterCmd.Flags().BoolVar(&clusterSmoke, "smoke", false, "Put a value on the first node and get it from the last one once the cluster is up")
+	rootCmd.AddCommand(clusterCmd)
+}
+
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Starts a local cluster of Kademlia nodes",
+	Long:  `Starts a number of Kademlia nodes in this process on consecutive localhost ports, bootstraps them into one network and prints their IDs and addresses. The cluster runs until interrupted. The nodes share the process wide metrics, such as RPC and lookup counts, so the /metrics endpoint of each node reports these for the whole cluster.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if clusterNodes < 1 {
+			log.Fatal("The cluster needs at least one node")
+		}
+		if clusterTopology != "star" && clusterTopology != "chain" {
+			log.Fatalf("Unknown topology %q, expected star or chain", clusterTopology)
+		}
+
+		nodes := make([]*node.Node, 0, clusterNodes)
+		apiAddresses := make([]string, clusterNodes)
+		defer func() {
+			for _, n := range nodes {
+				n.Close()
+			}
+		}()
+
+		for i := 0; i < clusterNodes; i++ {
+			config := node.DefaultConfig()
+			config.Listen = net.JoinHostPort(clusterHost, strconv.Itoa(clusterBasePort+i))
+			n, err := node.New(config)
+			if err != nil {
+				log.WithError(err).Fatalf("Failed to start node %d", i)
+			}
+			nodes = append(nodes, n)
+
+			if clusterAPIBasePort != 0 {
+				apiAddresses[i] = net.JoinHostPort(clusterHost, strconv.Itoa(clusterAPIBasePort+i))
+				go func(address string) {
+					if err := api.NewServer(n.Kademlia, n.Network).ListenAndServe(address); err != nil {
+						log.WithError(err).Fatal("Control API failed")
+					}
+				}(apiAddresses[i])
+			}
+
+			if i == 0 {
+				continue
+			}
+			bootstrap := nodes[0].Contact.Address
+			if clusterTopology == "chain" {
+				bootstrap = nodes[i-1].Contact.Address
+			}
+			if err := n.Bootstrap([]string{bootstrap}); err != nil {
+				log.WithError(err).Fatalf("Node %d failed to join the cluster", i)
+			}
+		}
+
+		fmt.Printf("%-5s %-40s  %-21s  %s\n", "NODE", "ID", "ADDRESS", "API")
+		for i, n := range nodes {
+			apiAddress := apiAddresses[i]
+			if apiAddress == "" {
+				apiAddress = "-"
+			}
+			fmt.Printf("%-5d %s  %-21s  %s\n", i, n.Contact.ID, n.Contact.Address, apiAddress)
+		}
+
+		if clusterSmoke {
+			if err := smokeTest(nodes[0], nodes[len(nodes)-1]); err != nil {
+				log.WithError(err).Fatal("Smoke test failed")
+			}
+			fmt.Println("Smoke test passed")
+		}
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+		log.Info("Stopping cluster")
+	},
+}
+
+// smokeTest puts a random value through one node and reads it back through another.
+func smokeTest(from, to *node.Node) error {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+	key, err := from.Kademlia.Put(data)
+	if err != nil {
+		return fmt.Errorf("put on %s: %w", from.Contact.Address, err)
+	}
+	// Forget the value on the originator so it does not keep republishing it.
+	from.Kademlia.Forget(key)
+
+	got, err := to.Kademlia.Get(key)
+	if err != nil {
+		return fmt.Errorf("get %s from %s: %w", key, to.Contact.Address, err)
+	}
+	if !bytes.Equal(got, data) {
+		return fmt.Errorf("get %s from %s returned different data", key, to.Contact.Address)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/crawl"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/node"
+)
+
+var crawlBootstrap []string
+var crawlListen string
+var crawlFormat string
+var crawlOutput string
+var crawlTargets int
+var crawlParallelism int
+
+func init() {
+	defaults := crawl.DefaultOptions()
+	crawlCmd.Flags().StringSliceVarP(&crawlBootstrap, "bootstrap", "b", nil, "Addresses of nodes to start crawling from")
+	crawlCmd.Flags().StringVar(&crawlListen, "listen", "127.0.0.1:0", "UDP address of the crawling node")
+	crawlCmd.Flags().StringVar(&crawlFormat, "format", "json", "Output format, json or dot")
+	crawlCmd.Flags().StringVarP(&crawlOutput, "output", "o", "", "File to write the map to instead of stdout")
+	crawlCmd.Flags().IntVar(&crawlTargets, "targets", defaults.Targets, "Number of FIND_NODE requests sent to each node, one per bucket")
+	crawlCmd.Flags().IntVar(&crawlParallelism, "parallelism", defaults.Parallelism, "Number of nodes crawled at once")
+	crawlCmd.MarkFlagRequired("bootstrap")
+	rootCmd.AddCommand(crawlCmd)
+}
+
+var crawlCmd = &cobra.Command{
+	Use:   "crawl",
+	Short: "Maps the overlay network reachable from a node",
+	Long: `Starts a temporary node that asks every node it learns about for the contacts in its buckets,
+until no new nodes turn up. It prints every node with its liveness, round trip time and the
+contacts it returned, as JSON or as a Graphviz DOT graph, and logs how many connected
+components the live nodes form.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if crawlFormat != "json" && crawlFormat != "dot" {
+			log.Fatalf("Unknown format %q, expected json or dot", crawlFormat)
+		}
+
+		config := node.DefaultConfig()
+		config.Listen = crawlListen
+		config.API.Listen = ""
+		n, err := node.New(config)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to start crawling node")
+		}
+		defer n.Close()
+
+		graph, err := crawl.Crawl(n.Network, n.Contact.ID, crawlBootstrap, crawl.Options{
+			Targets:     crawlTargets,
+			Parallelism: crawlParallelism,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("Crawl failed")
+		}
+
+		alive := 0
+		for _, node := range graph.Nodes {
+			if node.Alive {
+				alive++
+			}
+		}
+		log.WithFields(log.Fields{
+			"nodes":      len(graph.Nodes),
+			"alive":      alive,
+			"components": graph.Components,
+		}).Info("Crawl finished")
+
+		var data []byte
+		if crawlFormat == "dot" {
+			data = []byte(graph.DOT())
+		} else if data, err = graph.JSON(); err != nil {
+			log.WithError(err).Fatal("Failed to encode the map")
+		}
+
+		if crawlOutput == "" {
+			os.Stdout.Write(data)
+			return
+		}
+		if err := os.WriteFile(crawlOutput, data, 0o644); err != nil {
+			log.WithError(err).Fatal("Failed to write the map")
+		}
+	},
+}
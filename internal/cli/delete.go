@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addNodeFlag(deleteCmd)
+	rootCmd.AddCommand(deleteCmd)
+}
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete [hash]",
+	Short: "Deletes an object from the network.",
+	Long:  `Tells the running node that published the object to stop republishing it and to send signed tombstones to the nodes holding it, which then drop it. Copies cached on other nodes expire once their TTL runs out.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := newNodeClient().Delete(args[0]); err != nil {
+			log.Fatalf("Failed to delete object: %v", err)
+		}
+		fmt.Printf("Deleted %s\n", args[0])
+	},
+}
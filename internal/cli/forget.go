@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addNodeFlag(forgetCmd)
+	rootCmd.AddCommand(forgetCmd)
+}
+
+var forgetCmd = &cobra.Command{
+	Use:   "forget [hash]",
+	Short: "Stops republishing an object.",
+	Long:  `Tells the running node that published the object to stop republishing it. Copies held by other nodes expire once their TTL runs out.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := newNodeClient().Forget(args[0]); err != nil {
+			log.Fatalf("Failed to forget object: %v", err)
+		}
+		fmt.Printf("No longer republishing %s\n", args[0])
+	},
+}
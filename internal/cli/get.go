@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var getOutput string
+
+func init() {
+	addNodeFlag(getCmd)
+	getCmd.Flags().StringVarP(&getOutput, "output", "o", "", "Write the object to this file instead of stdout")
+	rootCmd.AddCommand(getCmd)
+}
+
+var getCmd = &cobra.Command{
+	Use:   "get [hash]",
+	Short: "Retrieves an object from the network by its hash.",
+	Long:  `Fetches the object stored under the given content hash through a running node and writes it to stdout or to the file given with --output.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := newNodeClient().Get(args[0])
+		if err != nil {
+			log.Fatalf("Failed to retrieve object: %v", err)
+		}
+
+		if getOutput != "" {
+			if err := os.WriteFile(getOutput, data, 0644); err != nil {
+				log.Fatalf("Failed to write %s: %v", getOutput, err)
+			}
+			return
+		}
+		if _, err := os.Stdout.Write(data); err != nil {
+			log.Fatalf("Failed to write object: %v", err)
+		}
+	},
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var lookupTrace string
+
+func init() {
+	addNodeFlag(lookupCmd)
+	lookupCmd.Flags().StringVar(&lookupTrace, "trace", "", "Print the full lookup trace in this format, json or dot")
+	rootCmd.AddCommand(lookupCmd)
+}
+
+var lookupCmd = &cobra.Command{
+	Use:   "lookup [id]",
+	Short: "Looks up the k closest nodes to an ID.",
+	Long:  `Asks a running node to perform an iterative node lookup for the given ID and prints the contacts queried in each round followed by the k closest contacts and their XOR distance to the ID.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if lookupTrace != "" {
+			trace, err := newNodeClient().LookupTrace(args[0], lookupTrace)
+			if err != nil {
+				log.Fatalf("Lookup failed: %v", err)
+			}
+			os.Stdout.Write(trace)
+			return
+		}
+
+		result, err := newNodeClient().Lookup(args[0])
+		if err != nil {
+			log.Fatalf("Lookup failed: %v", err)
+		}
+
+		fmt.Printf("Lookup for %s finished after %d rounds: %s\n", result.Target, len(result.Rounds), result.Reason)
+		for _, round := range result.Rounds {
+			fmt.Printf("\nRound %d, queried %d contacts:\n", round.Round, len(round.Queried))
+			for _, contact := range round.Queried {
+				fmt.Printf("  %s  %-21s  distance %s\n", contact.ID, contact.Address, contact.Distance)
+			}
+		}
+
+		fmt.Printf("\n%d closest contacts:\n", len(result.Contacts))
+		for i, contact := range result.Contacts {
+			fmt.Printf("%3d  %s  %-21s  distance %s\n", i+1, contact.ID, contact.Address, contact.Distance)
+		}
+	},
+}
@@ -0,0 +1,19 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/api"
+)
+
+// nodeAPIAddress is the control API address of the node that client commands talk to.
+var nodeAPIAddress string
+
+// addNodeFlag registers the --node flag on a command that talks to a running node.
+func addNodeFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&nodeAPIAddress, "node", "n", "127.0.0.1:9080", "Control API address of the running node")
+}
+
+// newNodeClient returns a client for the node selected with --node.
+func newNodeClient() *api.Client {
+	return api.NewClient(nodeAPIAddress)
+}
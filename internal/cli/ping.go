@@ -2,10 +2,10 @@ package cli
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
 	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addNodeFlag(putCmd)
+	rootCmd.AddCommand(putCmd)
+}
+
+var putCmd = &cobra.Command{
+	Use:   "put [file|-]",
+	Short: "Stores a file on the network and prints its hash.",
+	Long:  `Uploads the contents of a file, or of stdin when the argument is "-", through a running node and prints the key it is stored under: its content hash, or the Merkle root of its manifest when it is split into chunks.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var data []byte
+		var err error
+		if args[0] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			log.Fatalf("Failed to read input: %v", err)
+		}
+
+		hash, err := newNodeClient().Put(data)
+		if err != nil {
+			log.Fatalf("Failed to store object: %v", err)
+		}
+		fmt.Println(hash)
+	},
+}
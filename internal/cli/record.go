@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var recordGetOutput string
+
+func init() {
+	addNodeFlag(recordPutCmd)
+	addNodeFlag(recordGetCmd)
+	recordGetCmd.Flags().StringVarP(&recordGetOutput, "output", "o", "", "Write the value to this file instead of stdout")
+	recordCmd.AddCommand(recordPutCmd, recordGetCmd)
+	rootCmd.AddCommand(recordCmd)
+}
+
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Publishes and retrieves mutable signed records.",
+	Long:  `Records are stored under the hash of the owner's public key and a name. Only the owner can publish new versions, each with a higher sequence number.`,
+}
+
+var recordPutCmd = &cobra.Command{
+	Use:   "put [name] [file|-]",
+	Short: "Publishes a new version of a record and prints its key.",
+	Long:  `Reads the value from a file, or from stdin when the argument is "-", and publishes it through a running node as the next version of the named record, signed by the node's key. Values may be at most 16 KiB.`,
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var data []byte
+		var err error
+		if args[1] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(args[1])
+		}
+		if err != nil {
+			log.Fatalf("Failed to read input: %v", err)
+		}
+
+		record, err := newNodeClient().PutRecord(args[0], data)
+		if err != nil {
+			log.Fatalf("Failed to publish record: %v", err)
+		}
+		fmt.Printf("%s (sequence %d)\n", record.Key, record.Sequence)
+	},
+}
+
+var recordGetCmd = &cobra.Command{
+	Use:   "get [key]",
+	Short: "Retrieves the value of a record by its key.",
+	Long:  `Fetches the record stored under the given key through a running node, checks its signature and writes its value to stdout or to the file given with --output.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		record, err := newNodeClient().GetRecord(args[0])
+		if err != nil {
+			log.Fatalf("Failed to retrieve record: %v", err)
+		}
+
+		if recordGetOutput != "" {
+			if err := os.WriteFile(recordGetOutput, record.Value, 0644); err != nil {
+				log.Fatalf("Failed to write %s: %v", recordGetOutput, err)
+			}
+			return
+		}
+		if _, err := os.Stdout.Write(record.Value); err != nil {
+			log.Fatalf("Failed to write record: %v", err)
+		}
+	},
+}
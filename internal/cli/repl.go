@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
+)
+
+const replHelp = `Commands:
+  put <text>       store text on the network and print its hash
+  get <hash>       retrieve a value or object by its hash
+  lookup <id>      find the k closest nodes to an ID
+  ping <address>   ping a node by its address
+  table            print the routing table
+  help             show this help
+  exit             stop the node`
+
+// runREPL reads commands line by line from in and runs them against the local node
+// until "exit" is entered or in is closed.
+func runREPL(in io.Reader, out io.Writer, kademlia *dht.Kademlia, net *network.Network) {
+	fmt.Fprintf(out, "Node %s on %s. Type \"help\" for commands.\n", net.NodeID, net.LocalAddr())
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		command, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		switch command {
+		case "put":
+			if arg == "" {
+				fmt.Fprintln(out, "usage: put <text>")
+				continue
+			}
+			key, err := kademlia.Put([]byte(arg))
+			if err != nil {
+				fmt.Fprintf(out, "put failed: %v\n", err)
+				continue
+			}
+			fmt.Fprintln(out, key)
+		case "get":
+			key, err := dht.ParseKademliaID(arg)
+			if err != nil {
+				fmt.Fprintf(out, "invalid hash: %v\n", err)
+				continue
+			}
+			// Buffer the object so that a missing chunk does not print half of it.
+			var data bytes.Buffer
+			if err := kademlia.GetObject(key, &data); err != nil {
+				fmt.Fprintf(out, "get failed: %v\n", err)
+				continue
+			}
+			fmt.Fprintln(out, data.String())
+		case "lookup":
+			target, err := dht.ParseKademliaID(arg)
+			if err != nil {
+				fmt.Fprintf(out, "invalid id: %v\n", err)
+				continue
+			}
+			for i, contact := range kademlia.LookupContact(target) {
+				fmt.Fprintf(out, "%3d  %s  %-21s  distance %s\n", i+1, contact.ID, contact.Address, contact.ID.CalcDistance(target))
+			}
+		case "ping":
+			if arg == "" {
+				fmt.Fprintln(out, "usage: ping <address>")
+				continue
+			}
+			contact := dht.NewContact(dht.NewRandomKademliaID(), arg)
+			if err := net.Ping(&contact); err != nil {
+				fmt.Fprintf(out, "ping failed: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(out, "PONG from %s\n", contact.ID)
+		case "table":
+			me := kademlia.RoutingTable.Me()
+			for index, contacts := range kademlia.RoutingTable.Buckets() {
+				if len(contacts) == 0 {
+					continue
+				}
+				fmt.Fprintf(out, "Bucket %d (%d contacts):\n", index, len(contacts))
+				for _, contact := range contacts {
+					fmt.Fprintf(out, "  %s  %-21s  distance %s\n", contact.ID, contact.Address, contact.ID.CalcDistance(me.ID))
+				}
+			}
+		case "help":
+			fmt.Fprintln(out, replHelp)
+		case "exit", "quit":
+			return
+		default:
+			fmt.Fprintf(out, "unknown command %q, type \"help\" for commands\n", command)
+		}
+	}
+}
@@ -4,21 +4,55 @@ import (
 	"fmt"
 	"os"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 const TimeLayout = "2006-01-02 15:04:05"
 
 var Verbose bool
+var LogFormat string
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().StringVar(&LogFormat, "log-format", "text", "log format, text or json")
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "helloworld",
 	Short: "helloworld",
 	Long:  "helloworld",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupLogging()
+	},
+}
+
+// setupLogging configures logrus from the --verbose and --log-format flags.
+func setupLogging() error {
+	level := "info"
+	if Verbose {
+		level = "debug"
+	}
+	return configureLogging(level, LogFormat)
+}
+
+// configureLogging sets the logrus level and output format, text or json.
+func configureLogging(level, format string) error {
+	parsed, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(parsed)
+
+	switch format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true, TimestampFormat: TimeLayout})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return nil
 }
 
 func Execute() {
@@ -2,74 +2,164 @@ package cli
 
 import (
 	"fmt"
-	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
-	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/api"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/node"
 )
 
-var bootstrapAddress string
+var configFile string
+var bootstrapAddresses []string
+var listenAddress string
+var advertiseAddress string
+var dataDir string
 var port int
+var apiPort int
+var interactive bool
+var k int
+var alpha int
+var disjointPaths int
+var rpcTimeout time.Duration
 
 func init() {
-	startCmd.Flags().StringVarP(&bootstrapAddress, "bootstrap", "b", "", "Address of a bootstrap node to join the network")
-	startCmd.Flags().IntVarP(&port, "port", "p", 8080, "Port to listen on")
+	defaults := node.DefaultConfig()
+	startCmd.Flags().StringVarP(&configFile, "config", "c", "", "Config file (.json, .yaml or .toml), also read from "+node.EnvPrefix+"CONFIG")
+	startCmd.Flags().StringSliceVarP(&bootstrapAddresses, "bootstrap", "b", nil, "Addresses of bootstrap nodes to join the network through")
+	startCmd.Flags().StringVar(&listenAddress, "listen", defaults.Listen, "UDP address to listen on")
+	startCmd.Flags().StringVar(&advertiseAddress, "advertise", "", "Address other nodes reach this node on (defaults to the listen address)")
+	startCmd.Flags().StringVar(&dataDir, "data-dir", "", "Directory to keep the node identity in")
+	startCmd.Flags().IntVarP(&port, "port", "p", 8080, "Port to listen on, keeping the host of the listen address")
+	startCmd.Flags().IntVar(&apiPort, "api-port", 9080, "Port for the HTTP control API (0 disables it)")
+	startCmd.Flags().IntVarP(&k, "k", "k", defaults.K, "Bucket size and number of contacts returned by lookups")
+	startCmd.Flags().IntVar(&alpha, "alpha", defaults.Alpha, "Number of parallel requests per lookup round")
+	startCmd.Flags().IntVar(&disjointPaths, "disjoint-paths", defaults.DisjointPaths, "Number of disjoint paths lookups run over (1 for plain Kademlia)")
+	startCmd.Flags().DurationVar(&rpcTimeout, "rpc-timeout", time.Duration(defaults.Timeouts.RPC), "How long to wait for a response to a request")
+	startCmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Open an interactive shell on stdin instead of running in the background")
 	rootCmd.AddCommand(startCmd)
 }
 
 var startCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Starts a Kademlia node",
-	Long:  `Starts a Kademlia node, which will begin listening for incoming UDP messages.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		// Construct the listen address from the port flag.
-		listenAddr := fmt.Sprintf("127.0.0.1:%d", port)
-
-		nodeID := dht.NewRandomKademliaID()
-		log.Printf("Starting node with ID %s on %s", nodeID, listenAddr)
-
-		// Create the node's own contact details.
-		me := dht.NewContact(nodeID, listenAddr)
+	Long: `Starts a Kademlia node, which will begin listening for incoming UDP messages and serve the HTTP control API.
 
-		// Create a new routing table.
-		rt := dht.NewRoutingTable(me)
-
-		// Create the network layer.
-		net := network.NewNetwork(nodeID, rt, listenAddr)
-
-		// Start the network listener.
-		net.Listen()
+Settings are taken from the defaults, then the config file, then ` + node.EnvPrefix + `* environment
+variables (for example ` + node.EnvPrefix + `BOOTSTRAP or ` + node.EnvPrefix + `API_LISTEN) and finally the flags.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadStartConfig(cmd)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid configuration")
+		}
+		if err := configureLogging(config.Log.Level, config.Log.Format); err != nil {
+			log.WithError(err).Fatal("Invalid log settings")
+		}
 
-		// Create the Kademlia instance.
-		kademlia := dht.NewKademlia(rt, net)
+		n, err := node.New(config)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to start node")
+		}
+		log.WithFields(log.Fields{"node": n.Contact.ID.String(), "address": n.Contact.Address}).Info("Started node")
 
-		// If a bootstrap address is provided, join the network.
-		if bootstrapAddress != "" {
+		// Serve the HTTP control API.
+		if config.API.Listen != "" {
+			server := api.NewServer(n.Kademlia, n.Network)
 			go func() {
-				log.Printf("Joining network via bootstrap node at %s...", bootstrapAddress)
-
-				// Create a temporary contact for the bootstrap node (ID is unknown)
-				bootstrapContact := dht.NewContact(dht.NewRandomKademliaID(), bootstrapAddress)
+				if err := server.ListenAndServe(config.API.Listen); err != nil {
+					log.WithError(err).Fatal("Control API failed")
+				}
+			}()
+		}
 
-				// Ping the bootstrap node to get its real ID
-				if err := net.Ping(&bootstrapContact); err != nil {
-					log.Printf("Failed to ping bootstrap node: %v", err)
+		// If bootstrap addresses are provided, join the network.
+		if len(config.Bootstrap) > 0 {
+			go func() {
+				log.WithField("bootstrap", config.Bootstrap).Info("Joining network")
+				if err := n.Bootstrap(config.Bootstrap); err != nil {
+					log.WithError(err).Error("Failed to join network")
 					return
 				}
-				// The bootstrapContact ID is now updated from the PONG response.
-				log.Printf("Successfully contacted bootstrap node with ID %s", bootstrapContact.ID)
-
-				// Add the now-known bootstrap contact to the routing table
-				rt.AddContact(bootstrapContact, net)
-
-				// Perform a lookup for our own ID to populate the routing table.
-				kademlia.LookupContact(me.ID)
-				log.Println("Bootstrap process finished. Node is now part of the network.")
+				log.Info("Bootstrap process finished. Node is now part of the network.")
 			}()
 		}
 
+		if interactive {
+			runREPL(os.Stdin, os.Stdout, n.Kademlia, n.Network)
+			return
+		}
+
 		// Block forever to keep the listener running
 		select {}
 	},
 }
+
+// loadStartConfig layers the config file, the environment and the flags that were set on top of the defaults.
+func loadStartConfig(cmd *cobra.Command) (node.Config, error) {
+	config := node.DefaultConfig()
+	if configFile == "" {
+		configFile = os.Getenv(node.EnvPrefix + "CONFIG")
+	}
+	if configFile != "" {
+		var err error
+		if config, err = node.LoadConfig(configFile); err != nil {
+			return config, err
+		}
+	}
+	if err := config.ApplyEnv(os.LookupEnv); err != nil {
+		return config, err
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("listen") {
+		config.Listen = listenAddress
+	}
+	if flags.Changed("port") {
+		host, _, err := net.SplitHostPort(config.Listen)
+		if err != nil {
+			return config, fmt.Errorf("invalid listen address %q: %w", config.Listen, err)
+		}
+		config.Listen = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	if flags.Changed("advertise") {
+		config.Advertise = advertiseAddress
+	}
+	if flags.Changed("bootstrap") {
+		config.Bootstrap = bootstrapAddresses
+	}
+	if flags.Changed("data-dir") {
+		config.DataDir = dataDir
+	}
+	if flags.Changed("api-port") {
+		host := "127.0.0.1"
+		if current, _, err := net.SplitHostPort(config.API.Listen); err == nil {
+			host = current
+		}
+		config.API.Listen = ""
+		if apiPort != 0 {
+			config.API.Listen = net.JoinHostPort(host, strconv.Itoa(apiPort))
+		}
+	}
+	if flags.Changed("k") {
+		config.K = k
+	}
+	if flags.Changed("alpha") {
+		config.Alpha = alpha
+	}
+	if flags.Changed("disjoint-paths") {
+		config.DisjointPaths = disjointPaths
+	}
+	if flags.Changed("rpc-timeout") {
+		config.Timeouts.RPC = node.Duration(rpcTimeout)
+	}
+	if Verbose {
+		config.Log.Level = "debug"
+	}
+	if flags.Changed("log-format") {
+		config.Log.Format = LogFormat
+	}
+	return config, config.Validate()
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addNodeFlag(tableCmd)
+	rootCmd.AddCommand(tableCmd)
+}
+
+var tableCmd = &cobra.Command{
+	Use:   "table",
+	Short: "Prints the routing table of a running node.",
+	Long:  `Prints the index, size and contacts of every non-empty k-bucket in the routing table of a running node. Contacts are listed most recently seen first.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		table, err := newNodeClient().RoutingTable()
+		if err != nil {
+			log.Fatalf("Failed to fetch routing table: %v", err)
+		}
+
+		total := 0
+		for _, bucket := range table.Buckets {
+			total += bucket.Size
+		}
+		fmt.Printf("Routing table of %s: %d contacts in %d buckets\n", table.ID, total, len(table.Buckets))
+
+		for _, bucket := range table.Buckets {
+			fmt.Printf("\nBucket %d (%d contacts):\n", bucket.Index, bucket.Size)
+			for _, contact := range bucket.Contacts {
+				fmt.Printf("  %s  %-21s  distance %s\n", contact.ID, contact.Address, contact.Distance)
+			}
+		}
+	},
+}
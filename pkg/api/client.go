@@ -0,0 +1,132 @@
+// pkg/api/client.go
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client talks to the control API of a running node.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient returns a Client for the node whose control API listens on addr (host:port).
+func NewClient(addr string) *Client {
+	return &Client{
+		baseURL:    "http://" + addr,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Put stores data on the network and returns its content hash.
+func (c *Client) Put(data []byte) (string, error) {
+	var response PutResponse
+	err := c.do(http.MethodPut, "/objects", bytes.NewReader(data), &response)
+	return response.Hash, err
+}
+
+// Get retrieves the object stored under hash.
+func (c *Client) Get(hash string) ([]byte, error) {
+	httpResponse, err := c.request(http.MethodGet, "/objects/"+hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	return io.ReadAll(httpResponse.Body)
+}
+
+// Forget tells the node to stop republishing the object stored under hash.
+func (c *Client) Forget(hash string) error {
+	return c.do(http.MethodPost, "/objects/"+hash+"/forget", nil, &ForgetResponse{})
+}
+
+// Delete asks the node to delete the object stored under hash from the network.
+// It only succeeds for objects the node published.
+func (c *Client) Delete(hash string) error {
+	return c.do(http.MethodDelete, "/objects/"+hash, nil, &DeleteResponse{})
+}
+
+// PutRecord publishes value as the next version of the record called name,
+// signed by the node's identity.
+func (c *Client) PutRecord(name string, value []byte) (*RecordResponse, error) {
+	var response RecordResponse
+	err := c.do(http.MethodPut, "/records/"+url.PathEscape(name), bytes.NewReader(value), &response)
+	return &response, err
+}
+
+// GetRecord retrieves the record stored under key.
+func (c *Client) GetRecord(key string) (*RecordResponse, error) {
+	var response RecordResponse
+	err := c.do(http.MethodGet, "/records/"+key, nil, &response)
+	return &response, err
+}
+
+// Lookup runs a node lookup for id on the node and returns every round and the k closest contacts.
+func (c *Client) Lookup(id string) (*LookupResponse, error) {
+	var response LookupResponse
+	err := c.do(http.MethodGet, "/lookup/"+id, nil, &response)
+	return &response, err
+}
+
+// LookupTrace runs a node lookup for id on the node and returns its trace in
+// the given format, "json" or "dot".
+func (c *Client) LookupTrace(id, format string) ([]byte, error) {
+	httpResponse, err := c.request(http.MethodGet, "/lookup/"+id+"/trace?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	return io.ReadAll(httpResponse.Body)
+}
+
+// RoutingTable returns the non-empty buckets of the node's routing table.
+func (c *Client) RoutingTable() (*RoutingTableResponse, error) {
+	var response RoutingTableResponse
+	err := c.do(http.MethodGet, "/routing-table", nil, &response)
+	return &response, err
+}
+
+// Info returns information about the node.
+func (c *Client) Info() (*InfoResponse, error) {
+	var response InfoResponse
+	err := c.do(http.MethodGet, "/info", nil, &response)
+	return &response, err
+}
+
+// do sends a request and decodes the JSON response into v.
+func (c *Client) do(method, path string, body io.Reader, v any) error {
+	httpResponse, err := c.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	return json.NewDecoder(httpResponse.Body).Decode(v)
+}
+
+// request sends a request and turns non-2xx responses into errors.
+func (c *Client) request(method, path string, body io.Reader) (*http.Response, error) {
+	httpRequest, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	httpResponse, err := c.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	if httpResponse.StatusCode/100 != 2 {
+		defer httpResponse.Body.Close()
+		var apiErr ErrorResponse
+		if err := json.NewDecoder(httpResponse.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("node returned %s", httpResponse.Status)
+		}
+		return nil, fmt.Errorf("node returned %s: %s", httpResponse.Status, apiErr.Error)
+	}
+	return httpResponse, nil
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestClientPutGetForget(t *testing.T) {
+	servers := newTestCluster(t, 2)
+	publisher := NewClient(strings.TrimPrefix(servers[0].URL, "http://"))
+	reader := NewClient(strings.TrimPrefix(servers[1].URL, "http://"))
+
+	data := []byte("stored through the client")
+	hash, err := publisher.Put(data)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	value, err := reader.Get(hash)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !bytes.Equal(value, data) {
+		t.Errorf("Expected %q but got %q", data, value)
+	}
+
+	if err := publisher.Forget(hash); err != nil {
+		t.Errorf("Forget failed: %v", err)
+	}
+	// The reader never published the object, so it has nothing to forget.
+	if err := reader.Forget(hash); err == nil {
+		t.Error("Expected Forget on a node that did not publish the object to fail")
+	}
+}
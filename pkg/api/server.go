@@ -0,0 +1,339 @@
+// pkg/api/server.go
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/build"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/metrics"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
+)
+
+var logger = log.WithField("subsystem", "api")
+
+// maxObjectSize bounds the size of a request body accepted by PUT /objects and PUT /records.
+const maxObjectSize = dht.MaxChunks * dht.ChunkSize
+
+// Server exposes a running node over HTTP/JSON so that programs which cannot
+// link Go can still store and retrieve objects and inspect the node.
+type Server struct {
+	kademlia *dht.Kademlia
+	network  *network.Network
+	mux      *http.ServeMux
+	metrics  *metrics.Registry
+}
+
+// NewServer creates a Server for the given node.
+func NewServer(kademlia *dht.Kademlia, net *network.Network) *Server {
+	s := &Server{
+		kademlia: kademlia,
+		network:  net,
+		mux:      http.NewServeMux(),
+		metrics:  metrics.NewRegistry(),
+	}
+	s.registerNodeMetrics()
+
+	s.mux.HandleFunc("GET /health", s.handleHealth)
+	s.mux.HandleFunc("GET /info", s.handleInfo)
+	s.mux.HandleFunc("PUT /objects", s.handlePutObject)
+	s.mux.HandleFunc("POST /objects", s.handlePutObject)
+	s.mux.HandleFunc("GET /objects/{hash}", s.handleGetObject)
+	s.mux.HandleFunc("POST /objects/{hash}/forget", s.handleForgetObject)
+	s.mux.HandleFunc("DELETE /objects/{hash}", s.handleDeleteObject)
+	s.mux.HandleFunc("PUT /records/{name}", s.handlePutRecord)
+	s.mux.HandleFunc("GET /records/{key}", s.handleGetRecord)
+	s.mux.HandleFunc("GET /lookup/{id}", s.handleLookup)
+	s.mux.HandleFunc("GET /lookup/{id}/trace", s.handleLookupTrace)
+	s.mux.HandleFunc("GET /routing-table", s.handleRoutingTable)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on addr until the listener fails.
+func (s *Server) ListenAndServe(addr string) error {
+	logger.WithField("address", addr).Info("Control API listening")
+	return http.ListenAndServe(addr, s)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	store := s.kademlia.Store
+	quota := store.Quota()
+	writeJSON(w, http.StatusOK, InfoResponse{
+		ID:        s.network.NodeID.String(),
+		Address:   s.network.LocalAddr(),
+		Version:   build.BuildVersion,
+		BuildTime: build.BuildTime,
+		Storage: StorageInfo{
+			Keys:     store.Len(),
+			Bytes:    store.Bytes(),
+			MaxKeys:  quota.MaxKeys,
+			MaxBytes: quota.MaxBytes,
+			Eviction: string(quota.Policy),
+		},
+	})
+}
+
+func (s *Server) handlePutObject(w http.ResponseWriter, r *http.Request) {
+	key, err := s.kademlia.PutObject(http.MaxBytesReader(w, r.Body, maxObjectSize))
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr), errors.Is(err, dht.ErrObjectTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	case errors.Is(err, dht.ErrEmptyObject):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, PutResponse{Hash: key.String()})
+}
+
+func (s *Server) handleGetObject(w http.ResponseWriter, r *http.Request) {
+	key, err := dht.ParseKademliaID(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Buffer the object so that a missing chunk still results in an error status.
+	var data bytes.Buffer
+	err = s.kademlia.GetObject(key, &data)
+	if errors.Is(err, dht.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	data.WriteTo(w)
+}
+
+func (s *Server) handleForgetObject(w http.ResponseWriter, r *http.Request) {
+	key, err := dht.ParseKademliaID(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.kademlia.Forget(key) {
+		writeError(w, http.StatusNotFound, errors.New("object is not published by this node"))
+		return
+	}
+	writeJSON(w, http.StatusOK, ForgetResponse{Hash: key.String()})
+}
+
+func (s *Server) handlePutRecord(w http.ResponseWriter, r *http.Request) {
+	identity := s.network.Identity()
+	if identity == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("node has no identity to sign records with"))
+		return
+	}
+	name := r.PathValue("name")
+	if len(name) > dht.MaxRecordName {
+		writeError(w, http.StatusBadRequest, dht.ErrRecordTooLarge)
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, dht.MaxRecordValue))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	// The new version must have a higher sequence number than the current one.
+	sequence := uint64(1)
+	if current, err := s.kademlia.GetRecord(dht.RecordKey(identity.PublicKey, name)); err == nil {
+		sequence = current.Sequence + 1
+	}
+
+	record := dht.NewRecord(identity, name, sequence, value)
+	if err := s.kademlia.PublishRecord(record); err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, dht.ErrStaleRecord):
+			status = http.StatusConflict
+		case errors.Is(err, dht.ErrRecordTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newRecordResponse(record))
+}
+
+func (s *Server) handleGetRecord(w http.ResponseWriter, r *http.Request) {
+	key, err := dht.ParseKademliaID(r.PathValue("key"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	record, err := s.kademlia.GetRecord(key)
+	if errors.Is(err, dht.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newRecordResponse(record))
+}
+
+func (s *Server) handleDeleteObject(w http.ResponseWriter, r *http.Request) {
+	key, err := dht.ParseKademliaID(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.kademlia.Delete(key)
+	if errors.Is(err, dht.ErrNoIdentity) {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, DeleteResponse{Hash: key.String()})
+}
+
+func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	target, err := dht.ParseKademliaID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	contacts, recorder := s.tracedLookup(target)
+	trace := recorder.Trace()
+	response := LookupResponse{
+		Target:   target.String(),
+		Reason:   trace.Reason,
+		Contacts: newContactInfos(contacts, target),
+	}
+	for _, round := range trace.Rounds {
+		lookupRound := LookupRound{Path: round.Path, Round: round.Round}
+		for _, contact := range round.Queried {
+			lookupRound.Queried = append(lookupRound.Queried, ContactInfo(contact))
+		}
+		response.Rounds = append(response.Rounds, lookupRound)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleLookupTrace(w http.ResponseWriter, r *http.Request) {
+	target, err := dht.ParseKademliaID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	_, recorder := s.tracedLookup(target)
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, recorder.Trace())
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		io.WriteString(w, recorder.DOT())
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown trace format %q", format))
+	}
+}
+
+// tracedLookup runs a node lookup for target like the node does, over
+// disjoint paths if configured, while recording its trace.
+func (s *Server) tracedLookup(target *dht.KademliaID) ([]dht.Contact, *dht.TraceRecorder) {
+	recorder := dht.NewTraceRecorder(target)
+	lookup := dht.NewConfiguredLookup(s.kademlia.RoutingTable, s.kademlia.Network, target, s.kademlia.Config)
+	lookup.SetObserver(recorder.Observe)
+	return lookup.Start(), recorder
+}
+
+func (s *Server) handleRoutingTable(w http.ResponseWriter, r *http.Request) {
+	me := s.kademlia.RoutingTable.Me()
+	response := RoutingTableResponse{ID: me.ID.String()}
+	for index, contacts := range s.kademlia.RoutingTable.Buckets() {
+		if len(contacts) == 0 {
+			continue
+		}
+		response.Buckets = append(response.Buckets, BucketInfo{
+			Index:    index,
+			Size:     len(contacts),
+			Contacts: newContactInfos(contacts, me.ID),
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleMetrics writes the process wide metrics followed by those of this node.
+// Nodes running in the same process, like those started by the cluster
+// command, add to the same process wide counters and histograms.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := metrics.Default.WriteTo(w); err != nil {
+		logger.WithError(err).Warn("Failed to write metrics")
+		return
+	}
+	if _, err := s.metrics.WriteTo(w); err != nil {
+		logger.WithError(err).Warn("Failed to write metrics")
+	}
+}
+
+// registerNodeMetrics registers the gauges that describe this particular node.
+// They are computed at scrape time, unlike the process wide counters in metrics.Default.
+func (s *Server) registerNodeMetrics() {
+	s.metrics.NewGaugeFunc("kademlia_pending_responses", "Requests waiting for a response.", func() float64 {
+		return float64(s.network.PendingResponses())
+	})
+	s.metrics.NewGaugeVecFunc("kademlia_routing_table_contacts", "Contacts in each k-bucket of the routing table.", []string{"bucket"}, func() []metrics.LabeledValue {
+		buckets := s.kademlia.RoutingTable.Buckets()
+		samples := make([]metrics.LabeledValue, len(buckets))
+		for i, contacts := range buckets {
+			samples[i] = metrics.LabeledValue{Labels: []string{strconv.Itoa(i)}, Value: float64(len(contacts))}
+		}
+		return samples
+	})
+	s.metrics.NewGaugeFunc("kademlia_stored_keys", "Values held in the local store.", func() float64 {
+		return float64(s.kademlia.Store.Len())
+	})
+	s.metrics.NewGaugeFunc("kademlia_stored_bytes", "Total size of the values held in the local store.", func() float64 {
+		return float64(s.kademlia.Store.Bytes())
+	})
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.WithError(err).Warn("Failed to write API response")
+	}
+}
+
+// writeError writes err as a JSON error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
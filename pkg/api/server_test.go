@@ -0,0 +1,298 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
+)
+
+// newTestCluster starts count nodes on an in-memory network, all bootstrapped
+// via the first one, and returns an API test server for each.
+func newTestCluster(t *testing.T, count int) []*httptest.Server {
+	t.Helper()
+	mn := network.NewMemoryNetwork()
+	var servers []*httptest.Server
+	var first dht.Contact
+
+	for i := 0; i < count; i++ {
+		addr := fmt.Sprintf("node-%d", i)
+		transport, err := mn.Listen(addr)
+		if err != nil {
+			t.Fatalf("Failed to listen on %s: %v", addr, err)
+		}
+		identity, err := dht.NewIdentity(dht.DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodeID := identity.ID
+		me := dht.NewContact(nodeID, addr)
+		rt := dht.NewRoutingTable(me, dht.DefaultConfig())
+		store := dht.NewStore()
+		net := network.NewNetwork(nodeID, rt, store, transport)
+		net.SetIdentity(identity)
+		net.Listen()
+		kademlia := dht.NewKademlia(rt, net, store, dht.DefaultConfig())
+		kademlia.SetIdentity(identity)
+
+		if i == 0 {
+			first = me
+		} else {
+			rt.AddContact(first, net)
+			kademlia.LookupContact(nodeID)
+		}
+
+		server := httptest.NewServer(NewServer(kademlia, net))
+		t.Cleanup(func() {
+			server.Close()
+			net.Close()
+		})
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func TestPutOnOneNodeGetFromAnother(t *testing.T) {
+	servers := newTestCluster(t, 3)
+	data := []byte("stored through the control API")
+
+	request, _ := http.NewRequest(http.MethodPut, servers[1].URL+"/objects", bytes.NewReader(data))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201 but got %d", response.StatusCode)
+	}
+	var put PutResponse
+	if err := json.NewDecoder(response.Body).Decode(&put); err != nil {
+		t.Fatalf("Failed to decode PUT response: %v", err)
+	}
+	if put.Hash != dht.NewKademliaIDFromData(data).String() {
+		t.Errorf("Expected hash %s but got %s", dht.NewKademliaIDFromData(data), put.Hash)
+	}
+
+	response, err = http.Get(servers[2].URL + "/objects/" + put.Hash)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Errorf("Expected 200 with %q but got %d with %q", data, response.StatusCode, body)
+	}
+}
+
+func TestLargeObjectsRoundTrip(t *testing.T) {
+	servers := newTestCluster(t, 3)
+	data := bytes.Repeat([]byte("chunked "), dht.ChunkSize/2)
+
+	hash, err := NewClient(strings.TrimPrefix(servers[1].URL, "http://")).Put(data)
+	if err != nil {
+		t.Fatalf("Failed to store object: %v", err)
+	}
+	fetched, err := NewClient(strings.TrimPrefix(servers[2].URL, "http://")).Get(hash)
+	if err != nil {
+		t.Fatalf("Failed to retrieve object: %v", err)
+	}
+	if !bytes.Equal(fetched, data) {
+		t.Errorf("Expected %d bytes back but got %d different ones", len(data), len(fetched))
+	}
+}
+
+func TestDeleteObject(t *testing.T) {
+	servers := newTestCluster(t, 3)
+	publisher := NewClient(strings.TrimPrefix(servers[1].URL, "http://"))
+	other := NewClient(strings.TrimPrefix(servers[2].URL, "http://"))
+
+	hash, err := publisher.Put([]byte("must be pulled"))
+	if err != nil {
+		t.Fatalf("Failed to store object: %v", err)
+	}
+	if err := other.Delete(hash); err == nil {
+		t.Error("Expected a node that did not publish the object to fail to delete it")
+	}
+	if _, err := other.Get(hash); err != nil {
+		t.Fatalf("Expected the object to survive, got %v", err)
+	}
+
+	if err := publisher.Delete(hash); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+	if _, err := other.Get(hash); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected the object to be gone, got %v", err)
+	}
+}
+
+func TestGetObjectErrors(t *testing.T) {
+	servers := newTestCluster(t, 1)
+
+	response, err := http.Get(servers[0].URL + "/objects/not-a-hash")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a malformed hash but got %d", response.StatusCode)
+	}
+
+	response, err = http.Get(servers[0].URL + "/objects/" + dht.NewRandomKademliaID().String())
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing object but got %d", response.StatusCode)
+	}
+}
+
+func TestRecordsAreVersioned(t *testing.T) {
+	servers := newTestCluster(t, 3)
+	owner := NewClient(strings.TrimPrefix(servers[1].URL, "http://"))
+	reader := NewClient(strings.TrimPrefix(servers[2].URL, "http://"))
+
+	first, err := owner.PutRecord("status", []byte("first"))
+	if err != nil {
+		t.Fatalf("Failed to publish record: %v", err)
+	}
+	second, err := owner.PutRecord("status", []byte("second"))
+	if err != nil {
+		t.Fatalf("Failed to publish record: %v", err)
+	}
+	if first.Key != second.Key || first.Sequence != 1 || second.Sequence != 2 {
+		t.Errorf("Expected sequences 1 and 2 under one key, got %+v and %+v", first, second)
+	}
+
+	record, err := reader.GetRecord(second.Key)
+	if err != nil {
+		t.Fatalf("Failed to retrieve record: %v", err)
+	}
+	if record.Sequence != 2 || string(record.Value) != "second" {
+		t.Errorf("Expected the second version, got sequence %d with %q", record.Sequence, record.Value)
+	}
+}
+
+func TestOversizedRecordsAreRejected(t *testing.T) {
+	servers := newTestCluster(t, 2)
+	owner := NewClient(strings.TrimPrefix(servers[1].URL, "http://"))
+
+	_, err := owner.PutRecord("large", make([]byte, dht.MaxRecordValue+1))
+	if err == nil || !strings.Contains(err.Error(), "413") {
+		t.Fatalf("Expected a 413 for an oversized record, got %v", err)
+	}
+	record, err := owner.PutRecord("large", make([]byte, dht.MaxRecordValue))
+	if err != nil {
+		t.Fatalf("Failed to publish a record of the maximum size: %v", err)
+	}
+	if record.Sequence != 1 {
+		t.Errorf("Expected the rejected record to leave no version behind, got sequence %d", record.Sequence)
+	}
+}
+
+func TestRoutingTableAndInfo(t *testing.T) {
+	servers := newTestCluster(t, 2)
+
+	response, err := http.Get(servers[1].URL + "/routing-table")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer response.Body.Close()
+	var table RoutingTableResponse
+	if err := json.NewDecoder(response.Body).Decode(&table); err != nil {
+		t.Fatalf("Failed to decode routing table: %v", err)
+	}
+	if len(table.Buckets) != 1 || table.Buckets[0].Size != 1 {
+		t.Errorf("Expected a single bucket holding the bootstrap node, got %+v", table.Buckets)
+	}
+
+	response, err = http.Get(servers[1].URL + "/info")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer response.Body.Close()
+	var info InfoResponse
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		t.Fatalf("Failed to decode info: %v", err)
+	}
+	if info.ID != table.ID || info.Address != "node-1" {
+		t.Errorf("Unexpected node info %+v", info)
+	}
+}
+
+func TestLookupReportsRounds(t *testing.T) {
+	servers := newTestCluster(t, 4)
+	client := NewClient(strings.TrimPrefix(servers[3].URL, "http://"))
+
+	result, err := client.Lookup(dht.NewRandomKademliaID().String())
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if len(result.Rounds) == 0 || len(result.Rounds[0].Queried) == 0 {
+		t.Errorf("Expected at least one round with queried contacts, got %+v", result.Rounds)
+	}
+	if len(result.Contacts) != 3 {
+		t.Errorf("Expected the lookup to return the 3 other nodes, got %d contacts", len(result.Contacts))
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	servers := newTestCluster(t, 2)
+
+	response, err := http.Get(servers[1].URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	for _, expected := range []string{
+		`kademlia_rpc_sent_total{type="FIND_NODE"}`,
+		`kademlia_rpc_duration_seconds_bucket{type="FIND_NODE",le="+Inf"}`,
+		"kademlia_lookup_hops_count",
+		"kademlia_pending_responses 0",
+		`kademlia_routing_table_contacts{bucket="0"}`,
+		"kademlia_stored_keys 0",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected metrics to contain %q", expected)
+		}
+	}
+}
+
+func TestLookupTraceFormats(t *testing.T) {
+	servers := newTestCluster(t, 3)
+	client := NewClient(strings.TrimPrefix(servers[2].URL, "http://"))
+	target := dht.NewRandomKademliaID().String()
+
+	dot, err := client.LookupTrace(target, "dot")
+	if err != nil {
+		t.Fatalf("LookupTrace failed: %v", err)
+	}
+	if !strings.HasPrefix(string(dot), "digraph lookup {") {
+		t.Errorf("Unexpected DOT trace:\n%s", dot)
+	}
+
+	data, err := client.LookupTrace(target, "json")
+	if err != nil {
+		t.Fatalf("LookupTrace failed: %v", err)
+	}
+	var trace dht.LookupTrace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		t.Fatalf("Failed to decode JSON trace: %v", err)
+	}
+	if trace.Target != target || len(trace.Rounds) == 0 {
+		t.Errorf("Unexpected JSON trace %+v", trace)
+	}
+
+	if _, err := client.LookupTrace(target, "svg"); err == nil {
+		t.Error("Expected an unknown trace format to be rejected")
+	}
+}
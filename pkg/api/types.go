@@ -0,0 +1,120 @@
+// pkg/api/types.go
+package api
+
+import (
+	"encoding/hex"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+)
+
+// HealthResponse is returned by GET /health.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// InfoResponse is returned by GET /info.
+type InfoResponse struct {
+	ID        string      `json:"id"`
+	Address   string      `json:"address"`
+	Version   string      `json:"version"`
+	BuildTime string      `json:"build_time"`
+	Storage   StorageInfo `json:"storage"`
+}
+
+// StorageInfo describes how much of its storage quota a node uses. Zero limits mean no limit.
+type StorageInfo struct {
+	Keys     int    `json:"keys"`
+	Bytes    int    `json:"bytes"`
+	MaxKeys  int    `json:"max_keys"`
+	MaxBytes int    `json:"max_bytes"`
+	Eviction string `json:"eviction"`
+}
+
+// PutResponse is returned by PUT /objects.
+type PutResponse struct {
+	Hash string `json:"hash"`
+}
+
+// ForgetResponse is returned by POST /objects/{hash}/forget.
+type ForgetResponse struct {
+	Hash string `json:"hash"`
+}
+
+// DeleteResponse is returned by DELETE /objects/{hash}.
+type DeleteResponse struct {
+	Hash string `json:"hash"`
+}
+
+// RecordResponse is returned by PUT /records/{name} and GET /records/{key}.
+type RecordResponse struct {
+	Key       string `json:"key"`
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+	Sequence  uint64 `json:"sequence"`
+	Value     []byte `json:"value"`
+}
+
+// newRecordResponse converts record to a RecordResponse.
+func newRecordResponse(record *dht.Record) RecordResponse {
+	return RecordResponse{
+		Key:       record.Key().String(),
+		Name:      record.Name,
+		PublicKey: hex.EncodeToString(record.PublicKey),
+		Sequence:  record.Sequence,
+		Value:     record.Value,
+	}
+}
+
+// ContactInfo describes a contact and its XOR distance to some reference ID.
+type ContactInfo struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Distance string `json:"distance"`
+}
+
+// LookupRound lists the contacts queried in one round of a lookup. Path is
+// the index of the disjoint path the round belongs to.
+type LookupRound struct {
+	Path    int           `json:"path,omitempty"`
+	Round   int           `json:"round"`
+	Queried []ContactInfo `json:"queried"`
+}
+
+// LookupResponse is returned by GET /lookup/{id}.
+type LookupResponse struct {
+	Target   string        `json:"target"`
+	Reason   string        `json:"reason"`
+	Rounds   []LookupRound `json:"rounds"`
+	Contacts []ContactInfo `json:"contacts"`
+}
+
+// BucketInfo describes a single non-empty k-bucket.
+type BucketInfo struct {
+	Index    int           `json:"index"`
+	Size     int           `json:"size"`
+	Contacts []ContactInfo `json:"contacts"`
+}
+
+// RoutingTableResponse is returned by GET /routing-table.
+type RoutingTableResponse struct {
+	ID      string       `json:"id"`
+	Buckets []BucketInfo `json:"buckets"`
+}
+
+// ErrorResponse is returned with every non-2xx status.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// newContactInfos converts contacts to ContactInfos with distances relative to ref.
+func newContactInfos(contacts []dht.Contact, ref *dht.KademliaID) []ContactInfo {
+	infos := make([]ContactInfo, 0, len(contacts))
+	for _, contact := range contacts {
+		infos = append(infos, ContactInfo{
+			ID:       contact.ID.String(),
+			Address:  contact.Address,
+			Distance: contact.ID.CalcDistance(ref).String(),
+		})
+	}
+	return infos
+}
@@ -0,0 +1,312 @@
+// pkg/bench/bench.go
+package bench
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+)
+
+// Operations a benchmark can run
+const (
+	OpPut    = "put"
+	OpGet    = "get"
+	OpLookup = "lookup"
+)
+
+// Options describe a benchmark run
+type Options struct {
+	// Operations lists the operations to run, one phase each. Puts always run
+	// before gets, since gets read back the values written by the puts.
+	Operations []string
+	// Count is the number of operations per phase
+	Count int
+	// Concurrency is the number of operations in flight at once
+	Concurrency int
+	// ValueSize is the size in bytes of the values written by puts
+	ValueSize int
+}
+
+// Report summarizes one phase of a benchmark
+type Report struct {
+	Operation string
+	Count     int
+	Succeeded int
+	Duration  time.Duration
+	P50       time.Duration
+	P90       time.Duration
+	P99       time.Duration
+	Max       time.Duration
+	// Hops is the mean number of lookup rounds per operation
+	Hops float64
+	// RPCs is the mean number of requests sent per operation
+	RPCs float64
+}
+
+// SuccessRate returns the fraction of operations that succeeded
+func (report Report) SuccessRate() float64 {
+	if report.Count == 0 {
+		return 0
+	}
+	return float64(report.Succeeded) / float64(report.Count)
+}
+
+// Throughput returns the number of operations completed per second
+func (report Report) Throughput() float64 {
+	if report.Duration <= 0 {
+		return 0
+	}
+	return float64(report.Count) / report.Duration.Seconds()
+}
+
+// Runner runs benchmarks through a node's routing table and RPC. It drives
+// lookups directly instead of going through dht.Kademlia, so that hops and
+// requests can be counted per operation and values are never read from the
+// local store.
+type Runner struct {
+	routingTable *dht.RoutingTable
+	rpc          dht.RPC
+	config       dht.Config
+}
+
+// NewRunner returns a Runner using the given node
+func NewRunner(rt *dht.RoutingTable, rpc dht.RPC, config dht.Config) *Runner {
+	return &Runner{routingTable: rt, rpc: rpc, config: config}
+}
+
+// sample is the outcome of a single operation
+type sample struct {
+	latency time.Duration
+	hops    int
+	rpcs    int64
+	err     error
+}
+
+// Run runs the phases selected in options and returns one Report per phase
+func (runner *Runner) Run(options Options) ([]Report, error) {
+	if options.Count < 1 || options.Concurrency < 1 {
+		return nil, errors.New("count and concurrency must be at least 1")
+	}
+	selected := make(map[string]bool)
+	for _, operation := range options.Operations {
+		if operation != OpPut && operation != OpGet && operation != OpLookup {
+			return nil, fmt.Errorf("unknown operation %q, expected put, get or lookup", operation)
+		}
+		selected[operation] = true
+	}
+	if selected[OpGet] && !selected[OpPut] {
+		return nil, errors.New("get needs put, it reads back the values written by the puts")
+	}
+
+	var reports []Report
+	var values map[dht.KademliaID][]byte
+	keys := make([]dht.KademliaID, 0, options.Count)
+	if selected[OpPut] {
+		values = make(map[dht.KademliaID][]byte, options.Count)
+		data := make([][]byte, options.Count)
+		for i := range data {
+			data[i] = make([]byte, options.ValueSize)
+			if _, err := io.ReadFull(rand.Reader, data[i]); err != nil {
+				return nil, err
+			}
+			key := dht.NewKademliaIDFromData(data[i])
+			keys = append(keys, *key)
+			values[*key] = data[i]
+		}
+		reports = append(reports, runner.phase(OpPut, options, func(i int) sample {
+			return runner.put(&keys[i], values[keys[i]])
+		}))
+	}
+	if selected[OpGet] {
+		reports = append(reports, runner.phase(OpGet, options, func(i int) sample {
+			return runner.get(&keys[i], values[keys[i]])
+		}))
+	}
+	if selected[OpLookup] {
+		reports = append(reports, runner.phase(OpLookup, options, func(i int) sample {
+			return runner.lookup(dht.NewRandomKademliaID())
+		}))
+	}
+	return reports, nil
+}
+
+// phase runs options.Count operations with options.Concurrency workers
+func (runner *Runner) phase(operation string, options Options, run func(i int) sample) Report {
+	samples := make([]sample, options.Count)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	started := time.Now()
+	for w := 0; w < options.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				samples[i] = run(i)
+			}
+		}()
+	}
+	for i := 0; i < options.Count; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return summarize(operation, samples, time.Since(started))
+}
+
+// put looks up the k closest nodes to key and stores value on them.
+// It succeeds if at least one of them acknowledged the value.
+func (runner *Runner) put(key *dht.KademliaID, value []byte) sample {
+	rpc := &countingRPC{RPC: runner.rpc}
+	started := time.Now()
+	lookup := dht.NewConfiguredLookup(runner.routingTable, rpc, key, runner.config)
+	hops := countHops(lookup)
+	contacts := lookup.Start()
+
+	var stored atomic.Int64
+	var wg sync.WaitGroup
+	for _, contact := range contacts {
+		wg.Add(1)
+		go func(c dht.Contact) {
+			defer wg.Done()
+			if rpc.Store(&c, key, value, nil) == nil {
+				stored.Add(1)
+			}
+		}(contact)
+	}
+	wg.Wait()
+
+	result := sample{latency: time.Since(started), hops: *hops, rpcs: rpc.calls.Load()}
+	if stored.Load() == 0 {
+		result.err = errors.New("no node stored the value")
+	}
+	return result
+}
+
+// get runs a value lookup for key and checks the value found against expected
+func (runner *Runner) get(key *dht.KademliaID, expected []byte) sample {
+	rpc := &countingRPC{RPC: runner.rpc}
+	started := time.Now()
+	lookup := dht.NewConfiguredValueLookup(runner.routingTable, rpc, key, runner.config)
+	hops := countHops(lookup)
+	lookup.Start()
+
+	result := sample{latency: time.Since(started), hops: *hops, rpcs: rpc.calls.Load()}
+	switch {
+	case lookup.Value() == nil:
+		result.err = dht.ErrNotFound
+	case !bytes.Equal(lookup.Value(), expected):
+		result.err = errors.New("value does not match")
+	}
+	return result
+}
+
+// lookup runs a node lookup for target. It succeeds if any contact was found.
+func (runner *Runner) lookup(target *dht.KademliaID) sample {
+	rpc := &countingRPC{RPC: runner.rpc}
+	started := time.Now()
+	lookup := dht.NewConfiguredLookup(runner.routingTable, rpc, target, runner.config)
+	hops := countHops(lookup)
+	contacts := lookup.Start()
+
+	result := sample{latency: time.Since(started), hops: *hops, rpcs: rpc.calls.Load()}
+	if len(contacts) == 0 {
+		result.err = errors.New("no contacts found")
+	}
+	return result
+}
+
+// countHops observes lookup and returns where the number of rounds it ran ends up
+func countHops(lookup dht.Searcher) *int {
+	hops := new(int)
+	lookup.SetObserver(func(event dht.LookupEvent) {
+		if event.Type == dht.LookupFinished {
+			*hops = event.Round
+		}
+	})
+	return hops
+}
+
+// summarize computes a Report from the samples of one phase
+func summarize(operation string, samples []sample, duration time.Duration) Report {
+	report := Report{Operation: operation, Count: len(samples), Duration: duration}
+	latencies := make([]time.Duration, 0, len(samples))
+	var hops, rpcs int64
+	for _, s := range samples {
+		if s.err == nil {
+			report.Succeeded++
+		}
+		latencies = append(latencies, s.latency)
+		hops += int64(s.hops)
+		rpcs += s.rpcs
+	}
+	if len(samples) == 0 {
+		return report
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 50)
+	report.P90 = percentile(latencies, 90)
+	report.P99 = percentile(latencies, 99)
+	report.Max = latencies[len(latencies)-1]
+	report.Hops = float64(hops) / float64(len(samples))
+	report.RPCs = float64(rpcs) / float64(len(samples))
+	return report
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// countingRPC counts the requests sent through it
+type countingRPC struct {
+	dht.RPC
+	calls atomic.Int64
+}
+
+func (rpc *countingRPC) FindNode(contact *dht.Contact, target *dht.KademliaID) ([]dht.Contact, error) {
+	rpc.calls.Add(1)
+	return rpc.RPC.FindNode(contact, target)
+}
+
+func (rpc *countingRPC) Ping(contact *dht.Contact) error {
+	rpc.calls.Add(1)
+	return rpc.RPC.Ping(contact)
+}
+
+func (rpc *countingRPC) Store(contact *dht.Contact, key *dht.KademliaID, value []byte, publications []dht.Publication) error {
+	rpc.calls.Add(1)
+	return rpc.RPC.Store(contact, key, value, publications)
+}
+
+func (rpc *countingRPC) StoreRecord(contact *dht.Contact, record *dht.Record) error {
+	rpc.calls.Add(1)
+	return rpc.RPC.StoreRecord(contact, record)
+}
+
+func (rpc *countingRPC) Delete(contact *dht.Contact, tombstone *dht.Tombstone) error {
+	rpc.calls.Add(1)
+	return rpc.RPC.Delete(contact, tombstone)
+}
+
+func (rpc *countingRPC) Cache(contact *dht.Contact, key *dht.KademliaID, value []byte) error {
+	rpc.calls.Add(1)
+	return rpc.RPC.Cache(contact, key, value)
+}
+
+func (rpc *countingRPC) FindValue(contact *dht.Contact, key *dht.KademliaID) ([]byte, []dht.Contact, error) {
+	rpc.calls.Add(1)
+	return rpc.RPC.FindValue(contact, key)
+}
@@ -0,0 +1,89 @@
+package bench
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/node"
+)
+
+// newTestCluster starts count nodes on an in-memory network, all bootstrapped through the first.
+func newTestCluster(t *testing.T, count int) []*node.Node {
+	t.Helper()
+	mn := network.NewMemoryNetwork()
+	var nodes []*node.Node
+	for i := 0; i < count; i++ {
+		config := node.DefaultConfig()
+		config.Listen = fmt.Sprintf("node-%d", i)
+		transport, err := mn.Listen(config.Listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := node.NewWithTransport(config, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Close() })
+		if i > 0 {
+			if err := n.Bootstrap([]string{nodes[0].Contact.Address}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func TestRunReportsEveryPhase(t *testing.T) {
+	nodes := newTestCluster(t, 8)
+	client := nodes[len(nodes)-1]
+	runner := NewRunner(client.Kademlia.RoutingTable, client.Network, client.Kademlia.Config)
+
+	reports, err := runner.Run(Options{
+		Operations:  []string{OpLookup, OpGet, OpPut},
+		Count:       20,
+		Concurrency: 4,
+		ValueSize:   64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 || reports[0].Operation != OpPut || reports[1].Operation != OpGet || reports[2].Operation != OpLookup {
+		t.Fatalf("Expected put, get and lookup reports in that order, got %+v", reports)
+	}
+	for _, report := range reports {
+		if report.Count != 20 || report.SuccessRate() != 1 {
+			t.Errorf("%s: expected 20 successful operations, got %d of %d", report.Operation, report.Succeeded, report.Count)
+		}
+		if report.Hops < 1 || report.RPCs < 1 {
+			t.Errorf("%s: expected hops and rpcs to be counted, got %.1f and %.1f", report.Operation, report.Hops, report.RPCs)
+		}
+		if report.P50 > report.P90 || report.P90 > report.P99 || report.P99 > report.Max {
+			t.Errorf("%s: percentiles out of order: %+v", report.Operation, report)
+		}
+	}
+}
+
+func TestRunRejectsGetWithoutPut(t *testing.T) {
+	runner := NewRunner(nil, nil, node.DefaultConfig().DHT())
+	if _, err := runner.Run(Options{Operations: []string{OpGet}, Count: 1, Concurrency: 1}); err == nil {
+		t.Error("Expected get without put to be rejected")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[int]time.Duration{50: 50 * time.Millisecond, 90: 90 * time.Millisecond, 99: 99 * time.Millisecond} {
+		if got := percentile(latencies, p); got != expected {
+			t.Errorf("p%d: expected %v but got %v", p, expected, got)
+		}
+	}
+	if got := percentile(latencies[:1], 99); got != time.Millisecond {
+		t.Errorf("Expected the only sample, got %v", got)
+	}
+}
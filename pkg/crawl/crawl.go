@@ -0,0 +1,245 @@
+// pkg/crawl/crawl.go
+package crawl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+)
+
+// Options describe a crawl
+type Options struct {
+	// Targets is the number of FIND_NODE requests sent to each node. The
+	// targets are the node's own ID with one of its leading bits flipped, so
+	// each request asks for the contents of a different bucket.
+	Targets int
+	// Parallelism is the number of nodes crawled at once
+	Parallelism int
+}
+
+// DefaultOptions returns options that ask every node for its 16 farthest buckets, which hold nearly all its contacts
+func DefaultOptions() Options {
+	return Options{Targets: 16, Parallelism: 8}
+}
+
+// Node is a node found by a crawl
+type Node struct {
+	ID      string  `json:"id"`
+	Address string  `json:"address"`
+	Alive   bool    `json:"alive"`
+	RTTMS   float64 `json:"rtt_ms,omitempty"`
+	// Neighbours are the IDs of the nodes it returned
+	Neighbours []string `json:"neighbours,omitempty"`
+}
+
+// Graph is the map of the overlay produced by a crawl
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	// Components is the number of connected components formed by the live nodes, 1 for a healthy overlay
+	Components int     `json:"components"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// crawler holds the state of a crawl
+type crawler struct {
+	rpc     dht.RPC
+	self    *dht.KademliaID
+	options Options
+	mutex   sync.Mutex
+	nodes   map[dht.KademliaID]*Node
+	queue   chan dht.Contact
+	pending sync.WaitGroup
+}
+
+// Crawl maps the overlay reachable from the bootstrap addresses. It pings every
+// node it learns about and asks the live ones for their contacts until no new
+// nodes turn up. self is the ID of the crawling node, which is left out.
+func Crawl(rpc dht.RPC, self *dht.KademliaID, bootstrap []string, options Options) (*Graph, error) {
+	if options.Targets < 1 || options.Targets > dht.IDLength*8 || options.Parallelism < 1 {
+		return nil, fmt.Errorf("targets must be between 1 and %d and parallelism at least 1", dht.IDLength*8)
+	}
+	started := time.Now()
+	c := &crawler{
+		rpc:     rpc,
+		self:    self,
+		options: options,
+		nodes:   make(map[dht.KademliaID]*Node),
+		queue:   make(chan dht.Contact),
+	}
+
+	// The IDs of the bootstrap nodes are only known once they answer a ping.
+	var found []dht.Contact
+	for _, address := range bootstrap {
+		contact := dht.NewContact(dht.NewRandomKademliaID(), address)
+		if err := rpc.Ping(&contact); err == nil {
+			found = append(found, contact)
+		}
+	}
+	if len(found) == 0 {
+		return nil, errors.New("no bootstrap node answered")
+	}
+
+	for w := 0; w < options.Parallelism; w++ {
+		go func() {
+			for contact := range c.queue {
+				c.visit(contact)
+				c.pending.Done()
+			}
+		}()
+	}
+	c.discover(found)
+	c.pending.Wait()
+	close(c.queue)
+
+	graph := &Graph{DurationMS: float64(time.Since(started)) / float64(time.Millisecond)}
+	for _, node := range c.nodes {
+		sort.Strings(node.Neighbours)
+		graph.Nodes = append(graph.Nodes, *node)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+	graph.Components = components(graph.Nodes)
+	return graph, nil
+}
+
+// discover records contacts not seen before and queues them to be visited
+func (c *crawler) discover(contacts []dht.Contact) {
+	c.mutex.Lock()
+	var fresh []dht.Contact
+	for _, contact := range contacts {
+		if contact.ID.Equals(c.self) || c.nodes[*contact.ID] != nil {
+			continue
+		}
+		c.nodes[*contact.ID] = &Node{ID: contact.ID.String(), Address: contact.Address}
+		fresh = append(fresh, contact)
+	}
+	c.pending.Add(len(fresh))
+	c.mutex.Unlock()
+
+	// Queue from a new goroutine, the workers calling discover would otherwise block on each other.
+	go func() {
+		for _, contact := range fresh {
+			c.queue <- contact
+		}
+	}()
+}
+
+// visit pings a node and asks it for the contacts in its buckets
+func (c *crawler) visit(contact dht.Contact) {
+	// Ping overwrites the ID with the one of whoever answers, which may be a
+	// different node if the address is stale.
+	probe := contact
+	sent := time.Now()
+	if err := c.rpc.Ping(&probe); err != nil || !probe.ID.Equals(contact.ID) {
+		return
+	}
+	rtt := float64(time.Since(sent)) / float64(time.Millisecond)
+
+	neighbours := make(map[dht.KademliaID]dht.Contact)
+	for i := 0; i < c.options.Targets; i++ {
+		target := *contact.ID
+		target[i/8] ^= 0x80 >> (i % 8)
+		contacts, err := c.rpc.FindNode(&contact, &target)
+		if err != nil {
+			continue
+		}
+		for _, neighbour := range contacts {
+			if !neighbour.ID.Equals(contact.ID) && !neighbour.ID.Equals(c.self) {
+				neighbours[*neighbour.ID] = neighbour
+			}
+		}
+	}
+
+	found := make([]dht.Contact, 0, len(neighbours))
+	ids := make([]string, 0, len(neighbours))
+	for _, neighbour := range neighbours {
+		found = append(found, neighbour)
+		ids = append(ids, neighbour.ID.String())
+	}
+
+	c.mutex.Lock()
+	node := c.nodes[*contact.ID]
+	node.Alive = true
+	node.RTTMS = rtt
+	node.Neighbours = ids
+	c.mutex.Unlock()
+	c.discover(found)
+}
+
+// components counts the connected components of the live nodes, treating edges as undirected
+func components(nodes []Node) int {
+	parent := make(map[string]string)
+	var find func(id string) string
+	find = func(id string) string {
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	for _, node := range nodes {
+		if node.Alive {
+			parent[node.ID] = node.ID
+		}
+	}
+	count := len(parent)
+	for _, node := range nodes {
+		for _, neighbour := range node.Neighbours {
+			if _, alive := parent[neighbour]; !alive || !node.Alive {
+				continue
+			}
+			if a, b := find(node.ID), find(neighbour); a != b {
+				parent[a] = b
+				count--
+			}
+		}
+	}
+	return count
+}
+
+// JSON returns the graph as indented JSON
+func (graph *Graph) JSON() ([]byte, error) {
+	return json.MarshalIndent(graph, "", "  ")
+}
+
+// DOT returns the graph as a Graphviz digraph with an edge from every node to each contact it returned.
+// Dead nodes are drawn in red.
+func (graph *Graph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph overlay {\n")
+	alive := 0
+	for _, node := range graph.Nodes {
+		if node.Alive {
+			alive++
+		}
+	}
+	fmt.Fprintf(&b, "  label=\"%d nodes, %d alive, %d components\";\n", len(graph.Nodes), alive, graph.Components)
+	for _, node := range graph.Nodes {
+		label := fmt.Sprintf("%s\\n%s", shortID(node.ID), node.Address)
+		if node.Alive {
+			label += fmt.Sprintf("\\n%.1f ms", node.RTTMS)
+			fmt.Fprintf(&b, "  \"%s\" [label=\"%s\"];\n", node.ID, label)
+		} else {
+			fmt.Fprintf(&b, "  \"%s\" [label=\"%s\", color=red, fontcolor=red];\n", node.ID, label)
+		}
+	}
+	for _, node := range graph.Nodes {
+		for _, neighbour := range node.Neighbours {
+			fmt.Fprintf(&b, "  \"%s\" -> \"%s\";\n", node.ID, neighbour)
+		}
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+// shortID abbreviates a hex encoded ID for display
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
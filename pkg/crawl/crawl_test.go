@@ -0,0 +1,88 @@
+package crawl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/node"
+)
+
+// newTestNode starts a node on an in-memory network, bootstrapped through bootstrap unless it is empty.
+func newTestNode(t *testing.T, mn *network.MemoryNetwork, address, bootstrap string) *node.Node {
+	t.Helper()
+	config := node.DefaultConfig()
+	config.Listen = address
+	config.Timeouts.RPC = node.Duration(100 * time.Millisecond)
+	transport, err := mn.Listen(config.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := node.NewWithTransport(config, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	if bootstrap != "" {
+		if err := n.Bootstrap([]string{bootstrap}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return n
+}
+
+func TestCrawlFindsEveryNode(t *testing.T) {
+	mn := network.NewMemoryNetwork()
+	var nodes []*node.Node
+	for i := 0; i < 12; i++ {
+		bootstrap := ""
+		if i > 0 {
+			bootstrap = nodes[i-1].Contact.Address
+		}
+		nodes = append(nodes, newTestNode(t, mn, fmt.Sprintf("node-%d", i), bootstrap))
+	}
+	crawler := newTestNode(t, mn, "crawler", "")
+
+	// A node that is known to the others but no longer answers.
+	nodes[5].Close()
+
+	graph, err := Crawl(crawler.Network, crawler.Contact.ID, []string{nodes[0].Contact.Address}, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != len(nodes) {
+		t.Fatalf("Expected %d nodes but found %d", len(nodes), len(graph.Nodes))
+	}
+	for _, n := range graph.Nodes {
+		dead := n.ID == nodes[5].Contact.ID.String()
+		if n.Alive == dead {
+			t.Errorf("Node %s: expected alive=%v", n.Address, !dead)
+		}
+		if n.Alive && len(n.Neighbours) == 0 {
+			t.Errorf("Node %s: expected neighbours", n.Address)
+		}
+	}
+	if graph.Components != 1 {
+		t.Errorf("Expected one connected overlay, got %d components", graph.Components)
+	}
+
+	dot := graph.DOT()
+	if !strings.HasPrefix(dot, "digraph overlay {") || !strings.Contains(dot, "color=red") {
+		t.Errorf("Unexpected DOT output:\n%s", dot)
+	}
+}
+
+func TestComponents(t *testing.T) {
+	nodes := []Node{
+		{ID: "a", Alive: true, Neighbours: []string{"b"}},
+		{ID: "b", Alive: true},
+		{ID: "c", Alive: true, Neighbours: []string{"d"}},
+		{ID: "d", Alive: false, Neighbours: []string{"a"}},
+		{ID: "e", Alive: true},
+	}
+	if got := components(nodes); got != 3 {
+		t.Errorf("Expected 3 components but got %d", got)
+	}
+}
@@ -3,59 +3,152 @@ package dht
 
 import (
 	"container/list"
+	"sync"
+	"time"
 )
 
 // bucket definition
 // contains a List
 type bucket struct {
-	list *list.List
+	mutex  sync.Mutex
+	list   *list.List
+	size   int
+	limits *addressLimits
+	table  *addressLimits
 }
 
-// newBucket returns a new instance of a bucket
-func newBucket() *bucket {
+// newBucket returns a new instance of a bucket holding at most size contacts
+// with no limits on their addresses
+func newBucket(size int) *bucket {
 	bucket := &bucket{}
 	bucket.list = list.New()
+	bucket.size = size
+	bucket.limits = newAddressLimits(0, 0)
+	bucket.table = newAddressLimits(0, 0)
 	return bucket
 }
 
-// AddContact adds a new contact to the bucket.
-// It follows the LRU discipline: if the contact already exists, it's moved to the front.
-// If the bucket is full, the new contact is not added.
-func (bucket *bucket) AddContact(contact Contact, rpc RPC) {
-	var element *list.Element
-	// Find the contact in the bucket
-	for e := bucket.list.Front(); e != nil; e = e.Next() {
-		if (contact).ID.Equals(e.Value.(Contact).ID) {
-			element = e
-		}
+// reserve counts the contact's address against the bucket and table limits,
+// reporting false if either is exceeded. The caller must hold the bucket mutex.
+func (bucket *bucket) reserve(contact Contact) bool {
+	if !bucket.limits.reserve(contact.Address) {
+		return false
+	}
+	if !bucket.table.reserve(contact.Address) {
+		bucket.limits.release(contact.Address)
+		return false
 	}
+	return true
+}
+
+// release undoes reserve for a contact leaving the bucket. The caller must hold the bucket mutex.
+func (bucket *bucket) release(contact Contact) {
+	bucket.limits.release(contact.Address)
+	bucket.table.release(contact.Address)
+}
 
-	if element != nil {
+// AddContact adds a new contact to the bucket.
+// It follows the LRU discipline: if the contact already exists, it's moved to the front.
+// If the bucket is full, the new contact is not added. Neither is a contact
+// whose address would exceed the bucket's or the table's IP limits.
+// It returns the resulting changes, with the bucket index left for the caller to fill in.
+func (bucket *bucket) AddContact(contact Contact, rpc RPC) []RoutingEvent {
+	bucket.mutex.Lock()
+	if element := bucket.find(contact.ID); element != nil {
 		// If the contact already exists, move it to the front (most recently seen).
 		bucket.list.MoveToFront(element)
-	} else {
+		bucket.mutex.Unlock()
+		return []RoutingEvent{{Type: ContactRefreshed, Contact: contact}}
+	}
+	if bucket.list.Len() < bucket.size {
 		// If the contact does not exist, add it to the front if there is space.
-		if bucket.list.Len() < bucketSize {
-			bucket.list.PushFront(contact)
-		} else {
-			// If the bucket is full, ping the least-recently-seen contact (at the back).
-			lruContact := bucket.list.Back().Value.(Contact)
-			if err := rpc.Ping(&lruContact); err != nil {
-				// If the ping fails, evict the least-recently-seen contact and add the new one.
-				bucket.list.Remove(bucket.list.Back())
+		if !bucket.reserve(contact) {
+			bucket.mutex.Unlock()
+			routingRejections.WithLabelValues("address_limit").Inc()
+			return []RoutingEvent{{Type: ContactRejected, Contact: contact}}
+		}
+		bucket.list.PushFront(contact)
+		bucket.mutex.Unlock()
+		return []RoutingEvent{{Type: ContactAdded, Contact: contact}}
+	}
+	lruContact := bucket.list.Back().Value.(Contact)
+	bucket.mutex.Unlock()
+	events := []RoutingEvent{{Type: BucketFull, Contact: contact}}
+
+	// If the bucket is full, ping the least-recently-seen contact (at the back).
+	// The lock is not held during the ping so the bucket stays usable meanwhile.
+	err := rpc.Ping(&lruContact)
+
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	element := bucket.find(lruContact.ID)
+	if err != nil {
+		// If the ping fails, evict the least-recently-seen contact and add the new one.
+		if element != nil {
+			bucket.list.Remove(element)
+			bucket.release(element.Value.(Contact))
+			routingEvictions.Inc()
+			events = append(events, RoutingEvent{Type: ContactEvicted, Contact: element.Value.(Contact)})
+		}
+		if bucket.find(contact.ID) == nil && bucket.list.Len() < bucket.size {
+			if bucket.reserve(contact) {
 				bucket.list.PushFront(contact)
+				events = append(events, RoutingEvent{Type: ContactAdded, Contact: contact})
 			} else {
-				// If the ping succeeds, move the least-recently-seen contact to the front
-				// and discard the new contact.
-				bucket.list.MoveToFront(bucket.list.Back())
+				routingRejections.WithLabelValues("address_limit").Inc()
+				events = append(events, RoutingEvent{Type: ContactRejected, Contact: contact})
 			}
 		}
+	} else if element != nil {
+		// If the ping succeeds, move the least-recently-seen contact to the front
+		// and discard the new contact.
+		bucket.list.MoveToFront(element)
+		events = append(events, RoutingEvent{Type: ContactRefreshed, Contact: element.Value.(Contact)})
+	}
+	return events
+}
+
+// penalize lowers the reputation of the contact with the given id. A contact
+// that reaches MinReputation is evicted. It returns the resulting changes, with
+// the bucket index left for the caller to fill in.
+func (bucket *bucket) penalize(id *KademliaID) []RoutingEvent {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	element := bucket.find(id)
+	if element == nil {
+		return nil
+	}
+	contact := element.Value.(Contact)
+	contact.reputation = contact.Reputation() - 1
+	contact.penalized = time.Now()
+	if contact.reputation > MinReputation {
+		element.Value = contact
+		return nil
+	}
+	bucket.list.Remove(element)
+	bucket.release(contact)
+	routingEvictions.Inc()
+	return []RoutingEvent{{Type: ContactEvicted, Contact: contact}}
+}
+
+// find returns the list element holding the contact with the given id, or nil.
+// The caller must hold the bucket mutex.
+func (bucket *bucket) find(id *KademliaID) *list.Element {
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if id.Equals(e.Value.(Contact).ID) {
+			return e
+		}
 	}
+	return nil
 }
 
 // GetContactAndCalcDistance returns an array of Contacts where
 // the distance has already been calculated
 func (bucket *bucket) GetContactAndCalcDistance(target *KademliaID) []Contact {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
 	var contacts []Contact
 
 	for elt := bucket.list.Front(); elt != nil; elt = elt.Next() {
@@ -67,8 +160,30 @@ func (bucket *bucket) GetContactAndCalcDistance(target *KademliaID) []Contact {
 	return contacts
 }
 
+// Contacts returns the contacts in the bucket, most recently seen first
+func (bucket *bucket) Contacts() []Contact {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	contacts := make([]Contact, 0, bucket.list.Len())
+	for elt := bucket.list.Front(); elt != nil; elt = elt.Next() {
+		contacts = append(contacts, elt.Value.(Contact))
+	}
+	return contacts
+}
+
+// contains reports whether the bucket holds contact at the same address
+func (bucket *bucket) contains(contact Contact) bool {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	element := bucket.find(contact.ID)
+	return element != nil && element.Value.(Contact).Address == contact.Address
+}
+
 // Len return the size of the bucket
 func (bucket *bucket) Len() int {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
 	return bucket.list.Len()
 }
 
@@ -2,6 +2,7 @@ package dht
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -15,6 +16,31 @@ func (m *mockRPC) FindNode(contact *Contact, target *KademliaID) ([]Contact, err
 	return nil, nil
 }
 
+func (m *mockRPC) Store(contact *Contact, key *KademliaID, value []byte, publications []Publication) error {
+	// Not needed for this test
+	return nil
+}
+
+func (m *mockRPC) FindValue(contact *Contact, key *KademliaID) ([]byte, []Contact, error) {
+	// Not needed for this test
+	return nil, nil, nil
+}
+
+func (m *mockRPC) StoreRecord(contact *Contact, record *Record) error {
+	// Not needed for this test
+	return nil
+}
+
+func (m *mockRPC) Delete(contact *Contact, tombstone *Tombstone) error {
+	// Not needed for this test
+	return nil
+}
+
+func (m *mockRPC) Cache(contact *Contact, key *KademliaID, value []byte) error {
+	// Not needed for this test
+	return nil
+}
+
 func (m *mockRPC) Ping(contact *Contact) error {
 	if m.pingShouldFail {
 		return errors.New("ping failed")
@@ -25,7 +51,8 @@ func (m *mockRPC) Ping(contact *Contact) error {
 func TestBucketEviction(t *testing.T) {
 	// Test Case 1: Ping fails, least recently seen contact should be evicted.
 	t.Run("Ping Fails", func(t *testing.T) {
-		bucket := newBucket()
+		bucketSize := DefaultConfig().K
+		bucket := newBucket(bucketSize)
 		mockRPC := &mockRPC{pingShouldFail: true}
 
 		// Fill the bucket to its capacity.
@@ -66,7 +93,8 @@ func TestBucketEviction(t *testing.T) {
 
 	// Test Case 2: Ping succeeds, least recently seen contact should be moved to the front.
 	t.Run("Ping Succeeds", func(t *testing.T) {
-		bucket := newBucket()
+		bucketSize := DefaultConfig().K
+		bucket := newBucket(bucketSize)
 		mockRPC := &mockRPC{pingShouldFail: false}
 
 		// Fill the bucket to its capacity.
@@ -98,3 +126,58 @@ func TestBucketEviction(t *testing.T) {
 		}
 	})
 }
+
+func TestBucketIPLimits(t *testing.T) {
+	bucket := newBucket(DefaultConfig().K)
+	bucket.limits = newAddressLimits(2, 3)
+	rpc := &mockRPC{}
+
+	add := func(address string) RoutingEventType {
+		events := bucket.AddContact(NewContact(NewRandomKademliaID(), address), rpc)
+		return events[len(events)-1].Type
+	}
+
+	for _, address := range []string{"10.0.0.1:8000", "10.0.0.1:8001"} {
+		if got := add(address); got != ContactAdded {
+			t.Fatalf("Expected %s to be added, got %s", address, got)
+		}
+	}
+	if got := add("10.0.0.1:8002"); got != ContactRejected {
+		t.Errorf("Expected a third contact on the same IP to be rejected, got %s", got)
+	}
+	if got := add("10.0.0.2:8000"); got != ContactAdded {
+		t.Errorf("Expected another IP in the subnet to be added, got %s", got)
+	}
+	if got := add("10.0.0.3:8000"); got != ContactRejected {
+		t.Errorf("Expected a fourth contact in the same /24 to be rejected, got %s", got)
+	}
+	if got := add("10.0.1.1:8000"); got != ContactAdded {
+		t.Errorf("Expected a contact from another subnet to be added, got %s", got)
+	}
+	for i := 0; i < 5; i++ {
+		if got := add(fmt.Sprintf("127.0.0.1:%d", 9000+i)); got != ContactAdded {
+			t.Errorf("Expected loopback addresses not to be limited, got %s", got)
+		}
+	}
+	if bucket.Len() != 9 {
+		t.Errorf("Expected 9 contacts in the bucket, got %d", bucket.Len())
+	}
+}
+
+func TestAddressGroups(t *testing.T) {
+	tests := []struct {
+		address, ip, subnet string
+		ok                  bool
+	}{
+		{"192.168.1.17:8080", "192.168.1.17", "192.168.1.0", true},
+		{"[2001:db8::1]:8080", "2001:db8::1", "2001:db8::", true},
+		{"[::1]:8080", "", "", false},
+		{"node-a", "", "", false},
+	}
+	for _, test := range tests {
+		ip, subnet, ok := addressGroups(test.address)
+		if ip != test.ip || subnet != test.subnet || ok != test.ok {
+			t.Errorf("%s: expected (%q, %q, %v) but got (%q, %q, %v)", test.address, test.ip, test.subnet, test.ok, ip, subnet, ok)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// pkg/dht/cache.go
+package dht
+
+import (
+	"time"
+)
+
+// MinCacheTTL is the shortest time a node keeps a value it was asked to store.
+const MinCacheTTL = 10 * time.Minute
+
+// ScaledTTL returns how long to keep a value when closer nodes are known that
+// are closer to its key than this node. A node among the k closest keeps it for
+// ValueTTL. Farther away, where values end up through caching, the expiry is
+// inversely proportional to the number of closer nodes, as the Kademlia paper
+// suggests, so that caches do not outlive their usefulness.
+func ScaledTTL(closer, k int) time.Duration {
+	if closer < k {
+		return ValueTTL
+	}
+	return max(ValueTTL*time.Duration(k)/time.Duration(closer), MinCacheTTL)
+}
+
+// StoreTTL returns how long the owner of rt should keep a value cached under key.
+func StoreTTL(rt *RoutingTable, key *KademliaID) time.Duration {
+	return ScaledTTL(rt.CountCloser(key), rt.Config().K)
+}
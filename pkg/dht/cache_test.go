@@ -0,0 +1,104 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScaledTTL(t *testing.T) {
+	tests := []struct {
+		closer int
+		ttl    time.Duration
+	}{
+		{0, ValueTTL},
+		{19, ValueTTL},
+		{20, ValueTTL},
+		{40, ValueTTL / 2},
+		{80, ValueTTL / 4},
+		{1000000, MinCacheTTL},
+	}
+	for _, test := range tests {
+		if ttl := ScaledTTL(test.closer, 20); ttl != test.ttl {
+			t.Errorf("Expected a TTL of %v with %d closer nodes, got %v", test.ttl, test.closer, ttl)
+		}
+	}
+}
+
+func TestCountCloser(t *testing.T) {
+	config := DefaultConfig()
+	rt := NewRoutingTable(NewContact(NewKademliaID("8000000000000000000000000000000000000000"), "localhost:8000"), config)
+	mockRPC := &mockRPC{}
+	for _, id := range []string{
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000002",
+		"4000000000000000000000000000000000000000",
+		"C000000000000000000000000000000000000000",
+	} {
+		rt.AddContact(NewContact(NewKademliaID(id), "localhost:8001"), mockRPC)
+	}
+
+	key := NewKademliaID("0000000000000000000000000000000000000000")
+	if closer := rt.CountCloser(key); closer != 3 {
+		t.Errorf("Expected 3 contacts closer to %s, got %d", key, closer)
+	}
+	if closer := rt.CountCloser(rt.Me().ID); closer != 0 {
+		t.Errorf("Expected no contacts closer to our own ID, got %d", closer)
+	}
+	if ttl := StoreTTL(rt, key); ttl != ValueTTL {
+		t.Errorf("Expected the full TTL with fewer than k closer contacts, got %v", ttl)
+	}
+}
+
+func TestCountCloserEstimatesBeyondTheNeighbourhood(t *testing.T) {
+	config := DefaultConfig()
+	me := NewKademliaID("8000000000000000000000000000000000000000")
+	rt := NewRoutingTable(NewContact(me, "localhost:8000"), config)
+	mockRPC := &mockRPC{}
+	for i := 0; i < config.K; i++ {
+		id := *me
+		id[IDLength-1] = byte(i + 1)
+		rt.AddContact(NewContact(&id, "localhost:8001"), mockRPC)
+	}
+
+	far := NewKademliaID("0000000000000000000000000000000000000000")
+	if closer := rt.CountCloser(far); closer <= config.K {
+		t.Errorf("Expected far more than %d nodes to be estimated closer to a far key, got %d", config.K, closer)
+	}
+	if ttl := StoreTTL(rt, far); ttl != MinCacheTTL {
+		t.Errorf("Expected the shortest TTL for a far key, got %v", ttl)
+	}
+	near := NewKademliaID("8000000000000000000000000000000000000003")
+	if ttl := StoreTTL(rt, near); ttl != ValueTTL {
+		t.Errorf("Expected the full TTL for a key among our neighbours, got %v", ttl)
+	}
+}
+
+func TestCachedCopiesAreMarked(t *testing.T) {
+	store := NewStore()
+	cached := []byte("cached")
+	cachedKey := NewKademliaIDFromData(cached)
+	if err := store.Cache(cachedKey, cached, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !store.IsCached(cachedKey) {
+		t.Error("Expected the copy to be marked as cached")
+	}
+	// Storing the value as one of the k closest nodes clears the mark.
+	if err := store.Put(cachedKey, cached, ValueTTL); err != nil {
+		t.Fatal(err)
+	}
+	if store.IsCached(cachedKey) {
+		t.Error("Expected a stored value not to be marked as cached")
+	}
+
+	// Caching a value that is already stored does not mark it.
+	stored := []byte("stored")
+	storedKey := NewKademliaIDFromData(stored)
+	store.Put(storedKey, stored, ValueTTL)
+	if err := store.Cache(storedKey, stored, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if store.IsCached(storedKey) {
+		t.Error("Expected caching not to mark a stored value")
+	}
+}
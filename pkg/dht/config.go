@@ -0,0 +1,75 @@
+// pkg/dht/config.go
+package dht
+
+import (
+	"fmt"
+)
+
+// Config holds the tunable Kademlia parameters of a node.
+//
+// The ID length is not part of it. Keys are SHA-1 hashes of their values and
+// node IDs are hashes of public keys, so every node of a network has to agree
+// on IDLength, and a node configured with another length could not talk to
+// the rest. It stays a compile time constant, which also keeps a KademliaID
+// a comparable array usable as a map key.
+type Config struct {
+	// K is the size of each k-bucket and the number of contacts a lookup returns.
+	K int
+	// Alpha is the number of contacts queried in parallel in each lookup round.
+	Alpha int
+	// DisjointPaths is the number of disjoint paths lookups and value retrieval
+	// run over, as in S/Kademlia. 1 runs a plain Kademlia lookup.
+	DisjointPaths int
+	// StaticPuzzle is the number of leading zero bits H(H(public key)) must have
+	// for a node ID to be accepted, see Identity. 0 accepts any ID.
+	StaticPuzzle int
+	// DynamicPuzzle is the number of leading zero bits H(ID xor nonce) must have.
+	// 0 disables the dynamic puzzle.
+	DynamicPuzzle int
+	// BucketIPLimit and BucketSubnetLimit cap how many contacts in one bucket
+	// may share an IP address or a /24 (IPv6 /64) subnet. TableIPLimit and
+	// TableSubnetLimit do the same for the whole routing table. 0 means no
+	// limit. Loopback addresses are never limited.
+	BucketIPLimit     int
+	BucketSubnetLimit int
+	TableIPLimit      int
+	TableSubnetLimit  int
+}
+
+// DefaultConfig returns the parameters suggested by the Kademlia paper.
+func DefaultConfig() Config {
+	return Config{
+		K:             20,
+		Alpha:         3,
+		DisjointPaths: 1,
+	}
+}
+
+// Validate reports whether the parameters can be used.
+func (config Config) Validate() error {
+	if config.K < 1 {
+		return fmt.Errorf("k must be at least 1, got %d", config.K)
+	}
+	if config.Alpha < 1 {
+		return fmt.Errorf("alpha must be at least 1, got %d", config.Alpha)
+	}
+	if config.Alpha > config.K {
+		return fmt.Errorf("alpha (%d) must not be larger than k (%d)", config.Alpha, config.K)
+	}
+	if config.DisjointPaths < 1 {
+		return fmt.Errorf("disjoint paths must be at least 1, got %d", config.DisjointPaths)
+	}
+	if config.DisjointPaths > config.K {
+		return fmt.Errorf("disjoint paths (%d) must not be more than k (%d)", config.DisjointPaths, config.K)
+	}
+	if config.StaticPuzzle < 0 || config.StaticPuzzle > IDLength*8 {
+		return fmt.Errorf("static puzzle must be between 0 and %d bits, got %d", IDLength*8, config.StaticPuzzle)
+	}
+	if config.DynamicPuzzle < 0 || config.DynamicPuzzle > IDLength*8 {
+		return fmt.Errorf("dynamic puzzle must be between 0 and %d bits, got %d", IDLength*8, config.DynamicPuzzle)
+	}
+	if config.BucketIPLimit < 0 || config.BucketSubnetLimit < 0 || config.TableIPLimit < 0 || config.TableSubnetLimit < 0 {
+		return fmt.Errorf("ip limits must not be negative")
+	}
+	return nil
+}
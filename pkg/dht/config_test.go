@@ -0,0 +1,28 @@
+package dht
+
+import "testing"
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("Expected the default config to be valid, got %v", err)
+	}
+	for _, config := range []Config{{K: 0, Alpha: 1, DisjointPaths: 1}, {K: 20, Alpha: 0, DisjointPaths: 1}, {K: 2, Alpha: 3, DisjointPaths: 1}, {K: 20, Alpha: 3}, {K: 2, Alpha: 1, DisjointPaths: 3}} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestRoutingTableUsesConfiguredK(t *testing.T) {
+	config := Config{K: 2, Alpha: 1}
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"), config)
+	rpc := &mockRPC{pingShouldFail: true}
+	// All of these land in the same bucket.
+	for _, id := range []string{"0000000000000000000000000000000000000001", "0000000000000000000000000000000000000002", "0000000000000000000000000000000000000003"} {
+		rt.AddContact(NewContact(NewKademliaID(id), "localhost:8001"), rpc)
+	}
+	contacts := rt.FindClosestContacts(NewKademliaID("0000000000000000000000000000000000000000"), 10)
+	if len(contacts) != config.K {
+		t.Errorf("Expected the bucket to hold %d contacts, got %d", config.K, len(contacts))
+	}
+}
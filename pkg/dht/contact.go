@@ -4,19 +4,42 @@ package dht
 import (
 	"fmt"
 	"sort"
+	"time"
 )
 
+// MinReputation is the reputation at which a contact is evicted from the
+// routing table and, for DistrustTTL, no longer accepted into it.
+const MinReputation = -3
+
+// ReputationRecovery is how long it takes a contact to win back one point of
+// reputation, counted from its last penalty.
+const ReputationRecovery = 10 * time.Minute
+
 // Contact definition
-// stores the KademliaID, the ip address and the distance
+// stores the KademliaID, the ip address, the distance, the reputation and
+// when it was last lowered
 type Contact struct {
-	ID       *KademliaID
-	Address  string
-	distance *KademliaID
+	ID         *KademliaID
+	Address    string
+	distance   *KademliaID
+	reputation int
+	penalized  time.Time
 }
 
 // NewContact returns a new instance of a Contact
 func NewContact(id *KademliaID, address string) Contact {
-	return Contact{id, address, nil}
+	return Contact{id, address, nil, 0, time.Time{}}
+}
+
+// Reputation returns the reputation of the contact as kept by the routing
+// table. It starts at zero, drops each time the contact misbehaves and
+// recovers by one point every ReputationRecovery, up to zero again.
+func (contact *Contact) Reputation() int {
+	if contact.reputation == 0 {
+		return 0
+	}
+	recovered := int(time.Since(contact.penalized) / ReputationRecovery)
+	return min(0, contact.reputation+recovered)
 }
 
 // CalcDistance calculates the distance to the target and
@@ -46,6 +69,17 @@ func (candidates *ContactCandidates) Append(contacts []Contact) {
 	candidates.contacts = append(candidates.contacts, contacts...)
 }
 
+// Remove deletes every Contact with the given id from the ContactCandidates
+func (candidates *ContactCandidates) Remove(id *KademliaID) {
+	kept := candidates.contacts[:0]
+	for _, contact := range candidates.contacts {
+		if !contact.ID.Equals(id) {
+			kept = append(kept, contact)
+		}
+	}
+	candidates.contacts = kept
+}
+
 // GetContacts returns the first count number of Contacts
 func (candidates *ContactCandidates) GetContacts(count int) []Contact {
 	if count > len(candidates.contacts) {
@@ -0,0 +1,191 @@
+// pkg/dht/disjoint.go
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+// DisjointLookup runs several lookups for the same target over disjoint sets
+// of contacts, as described in S/Kademlia. The initial contacts are split
+// between the paths and no contact is queried by more than one path, so a
+// single malicious node returning bogus contacts can only steer its own path.
+type DisjointLookup struct {
+	paths    []*Lookup
+	config   Config
+	mutex    sync.Mutex
+	claimed  map[KademliaID]bool
+	initial  []Contact
+	observer LookupObserver
+	events   sync.Mutex
+}
+
+// NewDisjointLookup creates a DisjointLookup over config.DisjointPaths paths.
+func NewDisjointLookup(rt *RoutingTable, rpc RPC, target *KademliaID, config Config) *DisjointLookup {
+	initial := rt.FindClosestContacts(target, config.K)
+	disjoint := &DisjointLookup{
+		config:  config,
+		claimed: make(map[KademliaID]bool),
+		initial: initial,
+	}
+
+	for i := 0; i < config.DisjointPaths; i++ {
+		path := NewLookup(rt, rpc, target, config)
+		path.claim = disjoint.claim
+		// Deal out the initial contacts so every path starts from its own share.
+		path.initial = []Contact{}
+		for j := i; j < len(initial); j += config.DisjointPaths {
+			path.initial = append(path.initial, initial[j])
+		}
+		disjoint.paths = append(disjoint.paths, path)
+	}
+	return disjoint
+}
+
+// NewDisjointValueLookup creates a DisjointLookup whose paths send FIND_VALUE
+// and stop as soon as they find the value stored under key.
+func NewDisjointValueLookup(rt *RoutingTable, rpc RPC, key *KademliaID, config Config) *DisjointLookup {
+	disjoint := NewDisjointLookup(rt, rpc, key, config)
+	for _, path := range disjoint.paths {
+		path.findValue = true
+	}
+	return disjoint
+}
+
+// Searcher is a lookup running over a single path, a Lookup, or over several
+// disjoint paths, a DisjointLookup.
+type Searcher interface {
+	Start() []Contact
+	Value() []byte
+	ClosestWithoutValue() *Contact
+	SetObserver(observer LookupObserver)
+}
+
+// NewConfiguredLookup returns a DisjointLookup if config asks for more than
+// one disjoint path, and a plain Lookup otherwise.
+func NewConfiguredLookup(rt *RoutingTable, rpc RPC, target *KademliaID, config Config) Searcher {
+	if config.DisjointPaths > 1 {
+		return NewDisjointLookup(rt, rpc, target, config)
+	}
+	return NewLookup(rt, rpc, target, config)
+}
+
+// NewConfiguredValueLookup is like NewConfiguredLookup for value lookups.
+func NewConfiguredValueLookup(rt *RoutingTable, rpc RPC, key *KademliaID, config Config) Searcher {
+	if config.DisjointPaths > 1 {
+		return NewDisjointValueLookup(rt, rpc, key, config)
+	}
+	return NewValueLookup(rt, rpc, key, config)
+}
+
+// SetObserver registers a function that is called with the events of all
+// paths. The rounds of each path are reported with its index in Path, and a
+// single LookupStarted and LookupFinished event cover the whole lookup.
+// Events are delivered one at a time.
+func (d *DisjointLookup) SetObserver(observer LookupObserver) {
+	d.observer = observer
+	for i, path := range d.paths {
+		path.SetObserver(func(event LookupEvent) {
+			if event.Type == LookupStarted || event.Type == LookupFinished {
+				return
+			}
+			event.Path = i
+			d.emit(event)
+		})
+	}
+}
+
+// emit passes an event to the observer, if any.
+func (d *DisjointLookup) emit(event LookupEvent) {
+	if d.observer == nil {
+		return
+	}
+	d.events.Lock()
+	defer d.events.Unlock()
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	d.observer(event)
+}
+
+// Start runs all paths in parallel and returns the k closest contacts found by any of them.
+func (d *DisjointLookup) Start() []Contact {
+	started := time.Now()
+	d.emit(LookupEvent{Type: LookupStarted, Contacts: d.initial})
+
+	results := make([][]Contact, len(d.paths))
+	var wg sync.WaitGroup
+	for i, path := range d.paths {
+		wg.Add(1)
+		go func(i int, path *Lookup) {
+			defer wg.Done()
+			results[i] = path.Start()
+		}(i, path)
+	}
+	wg.Wait()
+
+	// A path that found a value may return contacts it never queried, which
+	// another path can hold as well.
+	merged := &ContactCandidates{}
+	seen := make(map[KademliaID]bool)
+	for _, result := range results {
+		for _, contact := range result {
+			if !seen[*contact.ID] {
+				seen[*contact.ID] = true
+				merged.Append([]Contact{contact})
+			}
+		}
+	}
+	merged.Sort()
+	result := merged.GetContacts(d.config.K)
+
+	// The paths run side by side, so the lookup took as many rounds as its longest path.
+	var rounds int
+	for _, path := range d.paths {
+		rounds = max(rounds, path.round)
+	}
+	lookupRounds.Observe(float64(rounds))
+	lookupDuration.Observe(time.Since(started).Seconds())
+	reason := ReasonConverged
+	switch {
+	case d.Value() != nil:
+		reason = ReasonValueFound
+	case len(result) == 0:
+		reason = ReasonExhausted
+	}
+	d.emit(LookupEvent{Type: LookupFinished, Round: rounds, Contacts: result, Reason: reason})
+	return result
+}
+
+// Value returns the value found by the first path that found one, or nil.
+func (d *DisjointLookup) Value() []byte {
+	for _, path := range d.paths {
+		if value := path.Value(); value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// ClosestWithoutValue returns the closest contact that answered any of the
+// paths without the value, or nil.
+func (d *DisjointLookup) ClosestWithoutValue() *Contact {
+	var closest *Contact
+	for _, path := range d.paths {
+		if missed := path.ClosestWithoutValue(); missed != nil && (closest == nil || missed.Less(closest)) {
+			closest = missed
+		}
+	}
+	return closest
+}
+
+// claim reserves id for the calling path. It reports false if another path got it first.
+func (d *DisjointLookup) claim(id *KademliaID) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.claimed[*id] {
+		return false
+	}
+	d.claimed[*id] = true
+	return true
+}
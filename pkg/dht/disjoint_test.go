@@ -0,0 +1,186 @@
+package dht
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingRPC remembers how often each contact was queried.
+type recordingRPC struct {
+	*simRPC
+	mutex   sync.Mutex
+	queried map[KademliaID]int
+}
+
+func (r *recordingRPC) FindNode(contact *Contact, target *KademliaID) ([]Contact, error) {
+	r.mutex.Lock()
+	r.queried[*contact.ID]++
+	r.mutex.Unlock()
+	return r.simRPC.FindNode(contact, target)
+}
+
+func TestDisjointLookupPathsDoNotShareNodes(t *testing.T) {
+	sim := newSimNetwork(300, 300, 5)
+	random := rand.New(rand.NewSource(6))
+	config := DefaultConfig()
+	config.DisjointPaths = 3
+
+	for i := 0; i < 10; i++ {
+		var origin *RoutingTable
+		for _, rt := range sim.nodes {
+			origin = rt
+			break
+		}
+		target := KademliaID{}
+		random.Read(target[:])
+
+		rpc := &recordingRPC{simRPC: sim.rpc(), queried: make(map[KademliaID]int)}
+		result := NewDisjointLookup(origin, rpc, &target, config).Start()
+		for id, count := range rpc.queried {
+			if count > 1 {
+				t.Fatalf("Lookup %d: %s was queried by %d paths", i, id.String(), count)
+			}
+		}
+
+		expected := sim.closestLive(&target, origin.Me().ID, config.K)
+		if len(result) != len(expected) {
+			t.Fatalf("Lookup %d: expected %d contacts but got %d", i, len(expected), len(result))
+		}
+		if !result[0].ID.Equals(expected[0].ID) {
+			t.Errorf("Lookup %d: expected the closest node %s, got %s", i, expected[0].ID, result[0].ID)
+		}
+	}
+}
+
+// liarRPC makes the liars answer every FIND_NODE with each other.
+type liarRPC struct {
+	*simRPC
+	liars []Contact
+}
+
+func (r *liarRPC) FindNode(contact *Contact, target *KademliaID) ([]Contact, error) {
+	for _, liar := range r.liars {
+		if liar.ID.Equals(contact.ID) {
+			return r.liars, nil
+		}
+	}
+	return r.simRPC.FindNode(contact, target)
+}
+
+func TestDisjointLookupSurvivesLyingNode(t *testing.T) {
+	sim := newSimNetwork(300, 300, 7)
+	var origin *RoutingTable
+	for _, rt := range sim.nodes {
+		origin = rt
+		break
+	}
+	target := NewRandomKademliaID()
+	// The node the lookup starts with answers with a set of colluding nodes far away from the target.
+	initial := origin.FindClosestContacts(target, 1)
+	liars := []Contact{initial[0]}
+	for _, contact := range sim.closestLive(target.CalcDistance(&KademliaID{0xFF}), origin.Me().ID, 40) {
+		liars = append(liars, contact)
+	}
+	rpc := &liarRPC{simRPC: sim.rpc(), liars: liars}
+
+	config := DefaultConfig()
+	config.DisjointPaths = 3
+	expected := sim.closestLive(target, origin.Me().ID, 1)[0]
+	result := NewDisjointLookup(origin, rpc, target, config).Start()
+	if !result[0].ID.Equals(expected.ID) {
+		t.Errorf("Expected the disjoint lookup to find %s despite the liar, got %s", expected.ID, result[0].ID)
+	}
+}
+
+func TestDisjointLookupIsRecordedOnce(t *testing.T) {
+	sim := newSimNetwork(100, 100, 9)
+	var origin *RoutingTable
+	for _, rt := range sim.nodes {
+		origin = rt
+		break
+	}
+	config := DefaultConfig()
+	config.DisjointPaths = 3
+
+	rounds, durations := lookupRounds.Count(), lookupDuration.Count()
+	NewDisjointLookup(origin, sim.rpc(), NewRandomKademliaID(), config).Start()
+	if got := lookupRounds.Count() - rounds; got != 1 {
+		t.Errorf("Expected the lookup hops to be recorded once, got %d", got)
+	}
+	if got := lookupDuration.Count() - durations; got != 1 {
+		t.Errorf("Expected the lookup duration to be recorded once, got %d", got)
+	}
+}
+
+func TestDisjointLookupTraceCoversAllPaths(t *testing.T) {
+	sim := newSimNetwork(200, 200, 8)
+	var origin *RoutingTable
+	for _, rt := range sim.nodes {
+		origin = rt
+		break
+	}
+	config := DefaultConfig()
+	config.DisjointPaths = 3
+	target := NewRandomKademliaID()
+
+	lookup := NewConfiguredLookup(origin, sim.rpc(), target, config)
+	if _, ok := lookup.(*DisjointLookup); !ok {
+		t.Fatalf("Expected a DisjointLookup for %d paths, got %T", config.DisjointPaths, lookup)
+	}
+	recorder := NewTraceRecorder(target)
+	var finished, hops int
+	lookup.SetObserver(func(event LookupEvent) {
+		recorder.Observe(event)
+		if event.Type == LookupFinished {
+			finished++
+			hops = event.Round
+		}
+	})
+	result := lookup.Start()
+
+	trace := recorder.Trace()
+	if finished != 1 {
+		t.Errorf("Expected a single finished event, got %d", finished)
+	}
+	if trace.Reason != ReasonConverged || len(trace.Result) != len(result) {
+		t.Errorf("Expected the trace to end with the %d contacts found, got %d (%s)", len(result), len(trace.Result), trace.Reason)
+	}
+	paths := make(map[int]int)
+	for _, round := range trace.Rounds {
+		paths[round.Path] = max(paths[round.Path], round.Round)
+	}
+	if len(paths) != config.DisjointPaths {
+		t.Errorf("Expected rounds of %d paths in the trace, got %v", config.DisjointPaths, paths)
+	}
+	for _, rounds := range paths {
+		if rounds > hops {
+			t.Errorf("Expected the lookup to report at least %d rounds, got %d", rounds, hops)
+		}
+	}
+}
+
+func TestDisjointLookupDOTShowsDepth(t *testing.T) {
+	target := NewRandomKademliaID()
+	recorder := NewTraceRecorder(target)
+	contacts := []Contact{NewContact(NewRandomKademliaID(), "a"), NewContact(NewRandomKademliaID(), "b")}
+	recorder.Observe(LookupEvent{Type: LookupStarted, Contacts: contacts})
+	for path, contact := range contacts {
+		for round := 1; round <= 2; round++ {
+			recorder.Observe(LookupEvent{Type: LookupRoundStarted, Path: path, Round: round, Contacts: []Contact{contact}})
+		}
+	}
+	recorder.Observe(LookupEvent{Type: LookupFinished, Contacts: contacts, Reason: ReasonConverged})
+
+	dot := recorder.DOT()
+	if !strings.Contains(dot, "2 rounds on 2 paths") {
+		t.Errorf("Expected the label to count the rounds of the deepest path:\n%s", dot)
+	}
+	for path := range contacts {
+		if !strings.Contains(dot, fmt.Sprintf("path %d round 1\\npath %d round 2", path, path)) {
+			t.Errorf("Expected every query of path %d to be labelled:\n%s", path, dot)
+		}
+	}
+}
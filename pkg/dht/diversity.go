@@ -0,0 +1,82 @@
+// pkg/dht/diversity.go
+package dht
+
+import (
+	"net"
+	"sync"
+)
+
+// addressLimits counts contacts per IP address and per subnet and caps both,
+// so that a single host or network running many IDs cannot take over a bucket
+// or the whole routing table. A limit of 0 means no limit.
+type addressLimits struct {
+	mutex     sync.Mutex
+	perIP     int
+	perSubnet int
+	ips       map[string]int
+	subnets   map[string]int
+}
+
+// newAddressLimits returns an addressLimits allowing perIP contacts per IP and perSubnet per subnet
+func newAddressLimits(perIP, perSubnet int) *addressLimits {
+	return &addressLimits{
+		perIP:     perIP,
+		perSubnet: perSubnet,
+		ips:       make(map[string]int),
+		subnets:   make(map[string]int),
+	}
+}
+
+// reserve counts address and reports true, or reports false without counting
+// it if that would exceed a limit.
+func (limits *addressLimits) reserve(address string) bool {
+	ip, subnet, ok := addressGroups(address)
+	if !ok {
+		return true
+	}
+	limits.mutex.Lock()
+	defer limits.mutex.Unlock()
+	if limits.perIP > 0 && limits.ips[ip] >= limits.perIP {
+		return false
+	}
+	if limits.perSubnet > 0 && limits.subnets[subnet] >= limits.perSubnet {
+		return false
+	}
+	limits.ips[ip]++
+	limits.subnets[subnet]++
+	return true
+}
+
+// release stops counting an address passed to reserve
+func (limits *addressLimits) release(address string) {
+	ip, subnet, ok := addressGroups(address)
+	if !ok {
+		return
+	}
+	limits.mutex.Lock()
+	defer limits.mutex.Unlock()
+	if limits.ips[ip]--; limits.ips[ip] <= 0 {
+		delete(limits.ips, ip)
+	}
+	if limits.subnets[subnet]--; limits.subnets[subnet] <= 0 {
+		delete(limits.subnets, subnet)
+	}
+}
+
+// addressGroups returns the IP of a host:port address and its /24 subnet,
+// or /64 for IPv6. Addresses that are not IPs, such as in-memory transport
+// names, and loopback addresses used for local clusters are not limited.
+func addressGroups(address string) (ip, subnet string, ok bool) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	parsed := net.ParseIP(host)
+	if parsed == nil || parsed.IsLoopback() {
+		return "", "", false
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String(), v4.Mask(net.CIDRMask(24, 32)).String(), true
+	}
+	return parsed.String(), parsed.Mask(net.CIDRMask(64, 128)).String(), true
+}
@@ -0,0 +1,100 @@
+// pkg/dht/events.go
+package dht
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RoutingEventType identifies a change in the RoutingTable
+type RoutingEventType int
+
+const (
+	// ContactAdded is emitted when a contact not seen before enters a bucket
+	ContactAdded RoutingEventType = iota
+	// ContactRefreshed is emitted when a known contact is moved to the front of its bucket
+	ContactRefreshed
+	// ContactEvicted is emitted when a contact is removed from its bucket
+	ContactEvicted
+	// BucketFull is emitted when Contact could not be added right away because its bucket is full
+	BucketFull
+	// ContactRejected is emitted when Contact was not added because its address is over the IP limits
+	// or it was evicted for its reputation before
+	ContactRejected
+)
+
+// String returns a string representation of the RoutingEventType
+func (t RoutingEventType) String() string {
+	switch t {
+	case ContactAdded:
+		return "added"
+	case ContactRefreshed:
+		return "refreshed"
+	case ContactEvicted:
+		return "evicted"
+	case BucketFull:
+		return "bucket_full"
+	case ContactRejected:
+		return "rejected"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// RoutingEvent describes a single change in the RoutingTable
+type RoutingEvent struct {
+	Type    RoutingEventType
+	Contact Contact
+	Bucket  int
+}
+
+// RoutingHandler receives RoutingTable events. It is called synchronously
+// from the goroutine that changed the table and should not block.
+type RoutingHandler func(event RoutingEvent)
+
+// subscribers keeps the handlers subscribed to a RoutingTable
+type subscribers struct {
+	mutex    sync.RWMutex
+	nextID   int
+	handlers map[int]RoutingHandler
+}
+
+// add registers handler and returns a function that removes it again
+func (s *subscribers) add(handler RoutingHandler) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[int]RoutingHandler)
+	}
+	id := s.nextID
+	s.nextID++
+	s.handlers[id] = handler
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.handlers, id)
+		})
+	}
+}
+
+// publish passes every event to every handler
+func (s *subscribers) publish(events []RoutingEvent) {
+	if len(events) == 0 {
+		return
+	}
+	s.mutex.RLock()
+	handlers := make([]RoutingHandler, 0, len(s.handlers))
+	for _, handler := range s.handlers {
+		handlers = append(handlers, handler)
+	}
+	s.mutex.RUnlock()
+
+	for _, event := range events {
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// pkg/dht/identity.go
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Identity is a node's keypair. The node ID is the hash of the public key, so
+// a node can prove it owns its ID by signing its messages.
+//
+// As in S/Kademlia, IDs must solve two crypto puzzles to be accepted. The
+// static puzzle requires H(H(public key)) to start with a number of zero bits,
+// which makes it expensive to pick an ID next to a given key since every
+// attempt needs a new keypair. The dynamic puzzle requires a Nonce such that
+// H(ID xor Nonce) starts with a number of zero bits, which lets the cost of
+// joining the network be raised without changing existing IDs.
+type Identity struct {
+	ID         *KademliaID
+	PublicKey  ed25519.PublicKey
+	PrivateKey ed25519.PrivateKey
+	Nonce      *KademliaID
+}
+
+// ErrPuzzle is returned when an ID does not solve the required crypto puzzles.
+var ErrPuzzle = errors.New("id does not solve the crypto puzzle")
+
+// NewIdentity generates keypairs until one solves the static puzzle of config,
+// then solves the dynamic puzzle for it.
+func NewIdentity(config Config) (*Identity, error) {
+	for {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := NewIdentityFromSeed(privateKey.Seed(), config)
+		if errors.Is(err, ErrPuzzle) {
+			continue
+		}
+		return identity, err
+	}
+}
+
+// NewIdentityFromSeed restores the Identity of an ed25519 seed, as returned
+// by PrivateKey.Seed. It fails with ErrPuzzle if the key does not solve the
+// static puzzle of config.
+func NewIdentityFromSeed(seed []byte, config Config) (*Identity, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("expected a %d byte seed but got %d bytes", ed25519.SeedSize, len(seed))
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	id := NewKademliaIDFromData(publicKey)
+	if leadingZeroBits(NewKademliaIDFromData(id[:])) < config.StaticPuzzle {
+		return nil, ErrPuzzle
+	}
+
+	return &Identity{
+		ID:         id,
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+		Nonce:      solveDynamicPuzzle(id, config.DynamicPuzzle),
+	}, nil
+}
+
+// Sign signs data with the identity's private key.
+func (identity *Identity) Sign(data []byte) []byte {
+	return ed25519.Sign(identity.PrivateKey, data)
+}
+
+// VerifyIdentity checks that id belongs to publicKey and solves the crypto
+// puzzles of config with the given nonce.
+func VerifyIdentity(id *KademliaID, publicKey []byte, nonce *KademliaID, config Config) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	if !NewKademliaIDFromData(publicKey).Equals(id) {
+		return errors.New("id is not the hash of the public key")
+	}
+	if leadingZeroBits(NewKademliaIDFromData(id[:])) < config.StaticPuzzle {
+		return ErrPuzzle
+	}
+	if config.DynamicPuzzle > 0 && (nonce == nil || !solvesDynamicPuzzle(id, nonce, config.DynamicPuzzle)) {
+		return ErrPuzzle
+	}
+	return nil
+}
+
+// solveDynamicPuzzle searches for a nonce such that H(id xor nonce) has at least difficulty leading zero bits.
+func solveDynamicPuzzle(id *KademliaID, difficulty int) *KademliaID {
+	nonce := KademliaID{}
+	for !solvesDynamicPuzzle(id, &nonce, difficulty) {
+		// Count upwards from the last byte.
+		for i := IDLength - 1; i >= 0; i-- {
+			nonce[i]++
+			if nonce[i] != 0 {
+				break
+			}
+		}
+	}
+	return &nonce
+}
+
+// solvesDynamicPuzzle reports whether H(id xor nonce) has at least difficulty leading zero bits.
+func solvesDynamicPuzzle(id, nonce *KademliaID, difficulty int) bool {
+	return leadingZeroBits(NewKademliaIDFromData(id.CalcDistance(nonce)[:])) >= difficulty
+}
+
+// leadingZeroBits returns the number of leading zero bits of id.
+func leadingZeroBits(id *KademliaID) int {
+	for i := 0; i < IDLength; i++ {
+		if id[i] != 0 {
+			return i*8 + bits.LeadingZeros8(id[i])
+		}
+	}
+	return IDLength * 8
+}
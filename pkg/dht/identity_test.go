@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIdentitySolvesPuzzles(t *testing.T) {
+	config := DefaultConfig()
+	config.StaticPuzzle = 6
+	config.DynamicPuzzle = 6
+
+	identity, err := NewIdentity(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyIdentity(identity.ID, identity.PublicKey, identity.Nonce, config); err != nil {
+		t.Errorf("Expected the generated identity to verify, got %v", err)
+	}
+
+	restored, err := NewIdentityFromSeed(identity.PrivateKey.Seed(), config)
+	if err != nil || !restored.ID.Equals(identity.ID) {
+		t.Errorf("Expected the seed to restore ID %s, got %v", identity.ID, err)
+	}
+}
+
+func TestVerifyIdentityRejectsForgedIDs(t *testing.T) {
+	config := DefaultConfig()
+	config.StaticPuzzle = 4
+	config.DynamicPuzzle = 4
+	identity, err := NewIdentity(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An ID next to a target key that is not the hash of the key.
+	forged := *identity.ID
+	forged[IDLength-1] ^= 1
+	if err := VerifyIdentity(&forged, identity.PublicKey, identity.Nonce, config); err == nil {
+		t.Error("Expected an ID that is not the hash of the public key to be rejected")
+	}
+
+	// A nonce that does not solve the dynamic puzzle.
+	config.DynamicPuzzle = 20
+	if err := VerifyIdentity(identity.ID, identity.PublicKey, identity.Nonce, config); !errors.Is(err, ErrPuzzle) {
+		t.Errorf("Expected ErrPuzzle for an unsolved dynamic puzzle, got %v", err)
+	}
+	if err := VerifyIdentity(identity.ID, identity.PublicKey, nil, config); !errors.Is(err, ErrPuzzle) {
+		t.Errorf("Expected ErrPuzzle for a missing nonce, got %v", err)
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	for id, expected := range map[string]int{
+		"ffffffffffffffffffffffffffffffffffffffff": 0,
+		"0fffffffffffffffffffffffffffffffffffffff": 4,
+		"0001000000000000000000000000000000000000": 15,
+		"0000000000000000000000000000000000000000": IDLength * 8,
+	} {
+		if got := leadingZeroBits(NewKademliaID(id)); got != expected {
+			t.Errorf("%s: expected %d leading zero bits but got %d", id, expected, got)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// pkg/dht/integrity.go
+package dht
+
+import (
+	"errors"
+)
+
+// ErrInvalidValue is reported for a contact that returned a value which does not belong to the requested key.
+var ErrInvalidValue = errors.New("value does not match its key")
+
+// ValidValue reports whether data may be stored under key: either its content
+// hash is the key, or it is a Manifest or Record that verifies against the key.
+func ValidValue(key *KademliaID, data []byte) bool {
+	if NewKademliaIDFromData(data).Equals(key) {
+		return true
+	}
+	if len(data) == 0 || data[0] != '{' {
+		return false
+	}
+	if _, err := DecodeManifest(key, data); err == nil {
+		return true
+	}
+	_, err := DecodeRecord(key, data)
+	return err == nil
+}
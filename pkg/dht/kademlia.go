@@ -1,22 +1,409 @@
 // pkg/dht/kademlia.go
 package dht
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RepublishInterval is how often the originator stores its values again,
+// well within ValueTTL so they never expire while still published.
+const RepublishInterval = 12 * time.Hour
+
+var (
+	// ErrNotFound is returned when no node in the network holds the requested value.
+	ErrNotFound = errors.New("value not found")
+	// ErrNoIdentity is returned by Delete when SetIdentity was not called.
+	ErrNoIdentity = errors.New("node has no identity to sign with")
+)
+
 // Kademlia represents a Kademlia node.
 type Kademlia struct {
 	RoutingTable *RoutingTable
 	Network      RPC
+	Store        *Store
+	Config       Config
+
+	mutex     sync.Mutex
+	published map[KademliaID][]byte
+	records   map[KademliaID]*Record
+	identity  *Identity
 }
 
 // NewKademlia creates a new Kademlia instance.
-func NewKademlia(rt *RoutingTable, rpc RPC) *Kademlia {
-	return &Kademlia{
+// It subscribes to the routing table so stored values are handed off to new nodes.
+func NewKademlia(rt *RoutingTable, rpc RPC, store *Store, config Config) *Kademlia {
+	kademlia := &Kademlia{
 		RoutingTable: rt,
 		Network:      rpc,
+		Store:        store,
+		Config:       config,
+		published:    make(map[KademliaID][]byte),
+		records:      make(map[KademliaID]*Record),
 	}
+	rt.Subscribe(func(event RoutingEvent) {
+		if event.Type == ContactAdded {
+			go kademlia.handOff(event.Contact)
+		}
+	})
+	return kademlia
+}
+
+// SetIdentity makes this node the publisher of the values it stores, so that
+// it can delete them again with tombstones signed by identity.
+// It must be called before values are published.
+func (k *Kademlia) SetIdentity(identity *Identity) {
+	k.identity = identity
 }
 
 // LookupContact performs the iterative lookup process to find the k closest contacts to the target.
+// With more than one disjoint path configured it runs a DisjointLookup.
 func (k *Kademlia) LookupContact(target *KademliaID) []Contact {
-	lookup := NewLookup(k.RoutingTable, k.Network, target)
-	return lookup.Start()
+	return NewConfiguredLookup(k.RoutingTable, k.Network, target, k.Config).Start()
+}
+
+// Put stores data on the k closest nodes to its content hash and returns the hash.
+// A copy is also kept in the local store so the originator can always serve it,
+// and the value is republished until Forget is called.
+func (k *Kademlia) Put(data []byte) (*KademliaID, error) {
+	key := NewKademliaIDFromData(data)
+
+	k.mutex.Lock()
+	k.published[*key] = data
+	k.mutex.Unlock()
+
+	return key, k.publish(key, data)
+}
+
+// Forget stops republishing the value stored under key, and the chunks of the
+// object if it is a manifest. It reports whether the value was published by
+// this node. Copies on other nodes expire after ValueTTL.
+func (k *Kademlia) Forget(key *KademliaID) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	data, ok := k.published[*key]
+	if ok && !NewKademliaIDFromData(data).Equals(key) {
+		if manifest, err := DecodeManifest(key, data); err == nil {
+			for _, chunk := range manifest.Chunks {
+				delete(k.published, chunk)
+			}
+		}
+	}
+	_, isRecord := k.records[*key]
+	delete(k.published, *key)
+	delete(k.records, *key)
+	return ok || isRecord
+}
+
+// Delete stops republishing the value stored under key and sends a tombstone
+// signed by this node to the k closest nodes, which drop the value and refuse
+// to store it again until the tombstone expires. The chunks of an object are
+// deleted along with its manifest. Nodes drop the value once no other
+// publisher vouches for it, and keep the tombstone even if they do not hold
+// the value yet. Copies cached along lookup paths expire on their own. It
+// succeeds if any node, including this one, accepted the tombstone for each
+// value.
+func (k *Kademlia) Delete(key *KademliaID) error {
+	if k.identity == nil {
+		return ErrNoIdentity
+	}
+
+	keys := []KademliaID{*key}
+	k.mutex.Lock()
+	data, ok := k.published[*key]
+	k.mutex.Unlock()
+	if !ok {
+		data, ok = k.Store.Get(key)
+	}
+	if ok && !NewKademliaIDFromData(data).Equals(key) {
+		if manifest, err := DecodeManifest(key, data); err == nil {
+			keys = append(keys, manifest.Chunks...)
+		}
+	}
+	k.Forget(key)
+
+	var errs []error
+	for _, key := range keys {
+		tombstone := NewTombstone(k.identity, &key)
+		localErr := k.Store.Delete(tombstone)
+		remoteErr := k.storeOnClosest(&key, func(contact *Contact) error {
+			return k.Network.Delete(contact, tombstone)
+		})
+		if localErr != nil && remoteErr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key.String(), remoteErr))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PublishRecord stores a signed record locally and on the k closest nodes to
+// its key, and republishes it until Forget is called or a newer version is
+// published. It fails if no node accepts the record, for example because they
+// already hold a higher sequence number, and with ErrRecordTooLarge before
+// anything is stored if the record exceeds MaxRecordName or MaxRecordValue.
+func (k *Kademlia) PublishRecord(record *Record) error {
+	if err := record.Verify(); err != nil {
+		return err
+	}
+	if err := k.Store.PutRecord(record, ValueTTL); errors.Is(err, ErrStoreFull) {
+		log.WithFields(log.Fields{"subsystem": "dht", "key": record.Key().String(), "error": err}).Warn("Failed to keep a local copy of record")
+	} else if err != nil {
+		return err
+	}
+	key := record.Key()
+
+	k.mutex.Lock()
+	k.records[*key] = record
+	k.mutex.Unlock()
+
+	return k.publishRecord(record)
+}
+
+// GetRecord returns the newest record stored under key. Older versions keep
+// valid signatures, so the local copy is compared with those held by each of
+// the k closest nodes, and the nodes that returned an older version are sent
+// the newest one. Records that do not verify are treated as missing, and the
+// nodes that returned them are penalized.
+func (k *Kademlia) GetRecord(key *KademliaID) (*Record, error) {
+	var newest *Record
+	if data, ok := k.Store.Get(key); ok {
+		if record, err := DecodeRecord(key, data); err == nil {
+			newest = record
+		}
+	}
+
+	type result struct {
+		contact Contact
+		record  *Record
+	}
+	contacts := k.LookupContact(key)
+	results := make(chan result, len(contacts))
+	var wg sync.WaitGroup
+	for _, contact := range contacts {
+		wg.Add(1)
+		go func(c Contact) {
+			defer wg.Done()
+			value, _, err := k.Network.FindValue(&c, key)
+			if err != nil || value == nil {
+				return
+			}
+			record, err := DecodeRecord(key, value)
+			if err != nil {
+				invalidValues.Inc()
+				k.RoutingTable.Penalize(c.ID)
+				return
+			}
+			results <- result{contact: c, record: record}
+		}(contact)
+	}
+	wg.Wait()
+	close(results)
+
+	var found []result
+	for result := range results {
+		found = append(found, result)
+		if newest == nil || result.record.Sequence > newest.Sequence {
+			newest = result.record
+		}
+	}
+	if newest == nil {
+		return nil, ErrNotFound
+	}
+
+	var stale []Contact
+	for _, result := range found {
+		if result.record.Sequence < newest.Sequence {
+			stale = append(stale, result.contact)
+		}
+	}
+	if len(stale) > 0 {
+		go func(record *Record) {
+			for _, contact := range stale {
+				if err := k.Network.StoreRecord(&contact, record); err != nil {
+					log.WithFields(log.Fields{"subsystem": "dht", "key": key.String(), "peer": contact.Address, "error": err}).Debug("Failed to update stale record")
+				}
+			}
+		}(newest)
+	}
+	return newest, nil
+}
+
+// Republish stores every value published by this node again.
+func (k *Kademlia) Republish() {
+	k.mutex.Lock()
+	published := make(map[KademliaID][]byte, len(k.published))
+	for key, data := range k.published {
+		published[key] = data
+	}
+	records := make([]*Record, 0, len(k.records))
+	for _, record := range k.records {
+		records = append(records, record)
+	}
+	k.mutex.Unlock()
+
+	for key, data := range published {
+		if err := k.publish(&key, data); err != nil {
+			log.WithFields(log.Fields{"subsystem": "dht", "key": key.String(), "error": err}).Warn("Failed to republish value")
+		}
+	}
+	for _, record := range records {
+		if err := k.publishRecord(record); err != nil {
+			log.WithFields(log.Fields{"subsystem": "dht", "key": record.Key().String(), "error": err}).Warn("Failed to republish record")
+		}
+	}
+}
+
+// StartRepublisher calls Republish every interval until the returned stop function is called.
+func (k *Kademlia) StartRepublisher(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				k.Republish()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// publish stores data locally and on the k closest nodes to key, along with a
+// fresh publication by this node if it has an identity. A full local store
+// does not keep the value from being published.
+func (k *Kademlia) publish(key *KademliaID, data []byte) error {
+	var publications []Publication
+	if k.identity != nil {
+		publications = []Publication{*NewPublication(k.identity, key)}
+	}
+	if err := k.Store.PutFrom(key, data, ValueTTL, publications); err != nil {
+		log.WithFields(log.Fields{"subsystem": "dht", "key": key.String(), "error": err}).Warn("Failed to keep a local copy of value")
+	}
+
+	return k.storeOnClosest(key, func(contact *Contact) error {
+		return k.Network.Store(contact, key, data, publications)
+	})
+}
+
+// publishRecord stores record on the k closest nodes to its key.
+func (k *Kademlia) publishRecord(record *Record) error {
+	return k.storeOnClosest(record.Key(), func(contact *Contact) error {
+		return k.Network.StoreRecord(contact, record)
+	})
+}
+
+// storeOnClosest calls store for each of the k closest nodes to key in
+// parallel. It succeeds if any of them does, or if there are no other nodes.
+func (k *Kademlia) storeOnClosest(key *KademliaID, store func(*Contact) error) error {
+	contacts := k.LookupContact(key)
+	if len(contacts) == 0 {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(contacts))
+	for _, contact := range contacts {
+		wg.Add(1)
+		go func(c Contact) {
+			defer wg.Done()
+			errs <- store(&c)
+		}(contact)
+	}
+	wg.Wait()
+	close(errs)
+
+	var lastErr error
+	for err := range errs {
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// Get returns the value stored under key, looking in the local store first.
+// Only values that are valid for key are returned.
+func (k *Kademlia) Get(key *KademliaID) ([]byte, error) {
+	if data, ok := k.Store.Get(key); ok && ValidValue(key, data) {
+		return data, nil
+	}
+
+	lookup := NewConfiguredValueLookup(k.RoutingTable, k.Network, key, k.Config)
+	lookup.Start()
+	value, missed := lookup.Value(), lookup.ClosestWithoutValue()
+	if value == nil {
+		return nil, ErrNotFound
+	}
+
+	// Cache the value on the closest node that did not have it, so that later
+	// lookups find it sooner. That node picks the TTL from its own position.
+	if missed != nil {
+		go func(contact Contact) {
+			if err := k.Network.Cache(&contact, key, value); err != nil {
+				log.WithFields(log.Fields{"subsystem": "dht", "key": key.String(), "peer": contact.Address, "error": err}).Debug("Failed to cache value")
+				return
+			}
+			pathCaches.Inc()
+		}(*missed)
+	}
+	return value, nil
+}
+
+// storeValue sends data to contact with STORE along with its publications,
+// or with STORE_RECORD if it is a record. Copies cached along a lookup path
+// are sent for caching, so the receiver keeps them for a shortened TTL too.
+func (k *Kademlia) storeValue(contact *Contact, key *KademliaID, data []byte) error {
+	if k.Store.IsCached(key) {
+		return k.Network.Cache(contact, key, data)
+	}
+	if !NewKademliaIDFromData(data).Equals(key) {
+		if record, err := DecodeRecord(key, data); err == nil {
+			return k.Network.StoreRecord(contact, record)
+		}
+	}
+	return k.Network.Store(contact, key, data, k.Store.Publications(key))
+}
+
+// handOff sends a newly discovered contact every stored value it is now
+// responsible for, as described in the Kademlia paper: those keys it is closer
+// to than this node, and for which it is among the k closest known nodes.
+func (k *Kademlia) handOff(contact Contact) {
+	me := k.RoutingTable.Me()
+	for _, key := range k.Store.Keys() {
+		if !contact.ID.CalcDistance(&key).Less(me.ID.CalcDistance(&key)) {
+			continue
+		}
+		if !containsContact(k.RoutingTable.FindClosestContacts(&key, k.Config.K), contact.ID) {
+			continue
+		}
+		data, ok := k.Store.Get(&key)
+		if !ok {
+			continue
+		}
+		if err := k.storeValue(&contact, &key, data); err != nil {
+			log.WithFields(log.Fields{"subsystem": "dht", "key": key.String(), "peer": contact.Address, "error": err}).Warn("Failed to hand off value")
+			continue
+		}
+		handOffs.Inc()
+	}
+}
+
+// containsContact reports whether contacts holds a contact with the given id.
+func containsContact(contacts []Contact, id *KademliaID) bool {
+	for _, contact := range contacts {
+		if contact.ID.Equals(id) {
+			return true
+		}
+	}
+	return false
 }
@@ -2,12 +2,15 @@
 package dht
 
 import (
+	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
 	"math/rand"
 )
 
-// the static number of bytes in a KademliaID
-const IDLength = 20
+// the static number of bytes in a KademliaID, the size of the SHA-1 hashes
+// that key values and derive node IDs
+const IDLength = sha1.Size
 
 // type definition of a KademliaID
 type KademliaID [IDLength]byte
@@ -24,6 +27,27 @@ func NewKademliaID(data string) *KademliaID {
 	return &newKademliaID
 }
 
+// ParseKademliaID parses a hex encoded KademliaID and reports malformed input
+func ParseKademliaID(data string) (*KademliaID, error) {
+	decoded, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) != IDLength {
+		return nil, fmt.Errorf("expected %d bytes but got %d", IDLength, len(decoded))
+	}
+
+	newKademliaID := KademliaID{}
+	copy(newKademliaID[:], decoded)
+	return &newKademliaID, nil
+}
+
+// NewKademliaIDFromData returns the content hash of data, used as its storage key
+func NewKademliaIDFromData(data []byte) *KademliaID {
+	newKademliaID := KademliaID(sha1.Sum(data))
+	return &newKademliaID
+}
+
 // NewRandomKademliaID returns a new instance of a random KademliaID
 func NewRandomKademliaID() *KademliaID {
 	newKademliaID := KademliaID{}
@@ -63,10 +87,16 @@ func (kademliaID KademliaID) CalcDistance(target *KademliaID) *KademliaID {
 	return &result
 }
 
+// float returns the KademliaID as a number, to compare the sizes of distances
+func (kademliaID *KademliaID) float() float64 {
+	var f float64
+	for _, b := range kademliaID {
+		f = f*256 + float64(b)
+	}
+	return f
+}
+
 // String returns a simple string representation of a KademliaID
 func (kademliaID *KademliaID) String() string {
 	return hex.EncodeToString(kademliaID[0:IDLength])
 }
-
-
-
@@ -2,11 +2,9 @@
 package dht
 
 import (
-	"sync"
+	"time"
 )
 
-const alpha = 3
-
 // Lookup holds the state for a single iterative lookup process.
 type Lookup struct {
 	shortlist    *ContactCandidates
@@ -14,102 +12,237 @@ type Lookup struct {
 	routingTable *RoutingTable
 	rpc          RPC
 	target       *KademliaID
+	config       Config
+	findValue    bool
+	value        []byte
+	round        int
+	seen         map[KademliaID]bool
+	observer     LookupObserver
+	initial      []Contact
+	claim        func(id *KademliaID) bool
+	missed       *Contact
 }
 
 // NewLookup creates a new Lookup instance.
-func NewLookup(rt *RoutingTable, rpc RPC, target *KademliaID) *Lookup {
+func NewLookup(rt *RoutingTable, rpc RPC, target *KademliaID, config Config) *Lookup {
 	return &Lookup{
 		shortlist:    &ContactCandidates{},
 		queried:      make(map[KademliaID]bool),
+		seen:         make(map[KademliaID]bool),
 		routingTable: rt,
 		rpc:          rpc,
 		target:       target,
+		config:       config,
 	}
 }
 
-// Start begins the iterative lookup process.
+// NewValueLookup creates a Lookup that sends FIND_VALUE instead of FIND_NODE
+// and stops as soon as one of the contacts returns the value stored under key.
+// Values that are not valid for key are rejected, and the contact that
+// returned them is penalized and treated like one that failed to respond.
+func NewValueLookup(rt *RoutingTable, rpc RPC, key *KademliaID, config Config) *Lookup {
+	lookup := NewLookup(rt, rpc, key, config)
+	lookup.findValue = true
+	return lookup
+}
+
+// Value returns the value found by a value lookup, or nil if none was found.
+func (l *Lookup) Value() []byte {
+	return l.value
+}
+
+// ClosestWithoutValue returns the closest contact that answered a value
+// lookup without the value, or nil. This is where the value gets cached.
+func (l *Lookup) ClosestWithoutValue() *Contact {
+	return l.missed
+}
+
+// SetObserver registers a function that is called with every event of the lookup.
+// Events are delivered one at a time from the goroutine running Start.
+func (l *Lookup) SetObserver(observer LookupObserver) {
+	l.observer = observer
+}
+
+// emit passes an event to the observer, if any.
+func (l *Lookup) emit(event LookupEvent) {
+	if l.observer == nil {
+		return
+	}
+	event.Round = l.round
+	event.Time = time.Now()
+	l.observer(event)
+}
+
+// Start begins the iterative lookup process. It only ends once each of the
+// k closest contacts known has been queried and responded, or a value lookup
+// found its value. Contacts that fail to respond are dropped from the shortlist.
 func (l *Lookup) Start() []Contact {
-	// Start with the alpha closest nodes from our own routing table
-	initialContacts := l.routingTable.FindClosestContacts(l.target, alpha)
-	l.shortlist.Append(initialContacts)
-
-	// Keep track of the closest contact found so far
-	var closestContact *Contact
-	if l.shortlist.Len() > 0 {
-		// GetContacts returns a slice, so we take the first element.
-		closestContact = &l.shortlist.GetContacts(1)[0]
+	// The paths of a disjoint lookup are recorded once for the whole lookup.
+	if l.claim == nil {
+		started := time.Now()
+		defer func() {
+			lookupRounds.Observe(float64(l.round))
+			lookupDuration.Observe(time.Since(started).Seconds())
+		}()
 	}
 
+	// Never query ourselves, even if a peer returns our own contact.
+	l.seen[*l.routingTable.Me().ID] = true
+
+	// Start with the k closest nodes from our own routing table, unless
+	// this is one path of a disjoint lookup that was given its own share.
+	initial := l.initial
+	if initial == nil {
+		initial = l.routingTable.FindClosestContacts(l.target, l.config.K)
+	}
+	initialContacts := l.add(initial)
+	l.emit(LookupEvent{Type: LookupStarted, Contacts: initialContacts})
+
+	// Distance of the closest contact found so far
+	var closest *KademliaID
+
 	// Main lookup loop
+	reason := ReasonConverged
+	parallelism := l.config.Alpha
 	for {
-		contactsToQuery := l.getUnqueriedContacts(alpha)
-
+		contactsToQuery := l.getUnqueriedContacts(parallelism)
 		if len(contactsToQuery) == 0 {
+			if l.shortlist.Len() == 0 {
+				reason = ReasonExhausted
+			}
 			break
 		}
 
-		newContacts := l.queryContacts(contactsToQuery)
-		l.shortlist.Append(newContacts)
-		l.shortlist.Sort()
-
-		if l.shortlist.Len() > 0 && (closestContact == nil || l.shortlist.GetContacts(1)[0].Less(closestContact)) {
-			closestContact = &l.shortlist.GetContacts(1)[0]
-		} else {
-			// No closer contact found, so we are getting closer to the end.
-			// Query the top k contacts that haven't been queried yet to be sure.
-			remainingToQuery := l.getUnqueriedContacts(BucketSize)
-			if len(remainingToQuery) > 0 {
-				newContacts := l.queryContacts(remainingToQuery)
-				l.shortlist.Append(newContacts)
-				l.shortlist.Sort()
-			}
+		l.queryContacts(contactsToQuery)
+		if l.value != nil {
+			reason = ReasonValueFound
 			break
 		}
+
+		// If the round did not get us any closer, query all of the
+		// k closest contacts that have not been queried yet at once.
+		parallelism = l.config.Alpha
+		best := l.closestDistance()
+		if best == nil || (closest != nil && !best.Less(closest)) {
+			parallelism = l.config.K
+		}
+		closest = best
 	}
 
-	return l.shortlist.GetContacts(BucketSize)
+	result := l.shortlist.GetContacts(l.config.K)
+	l.emit(LookupEvent{Type: LookupFinished, Contacts: result, Reason: reason})
+	return result
 }
 
-func (l *Lookup) getUnqueriedContacts(count int) []Contact {
-	var contacts []Contact
-	for _, contact := range l.shortlist.GetContacts(l.shortlist.Len()) {
-		if len(contacts) >= count {
-			break
+// add puts contacts not seen before on the shortlist and returns them.
+func (l *Lookup) add(contacts []Contact) []Contact {
+	var added []Contact
+	for _, contact := range contacts {
+		if l.seen[*contact.ID] {
+			continue
 		}
-		if !l.queried[*contact.ID] {
+		l.seen[*contact.ID] = true
+		// Contacts received over the network carry no distance yet.
+		contact.CalcDistance(l.target)
+		added = append(added, contact)
+	}
+	l.shortlist.Append(added)
+	l.shortlist.Sort()
+	return added
+}
+
+// closestDistance returns a copy of the distance of the closest contact on the shortlist, or nil if it is empty.
+func (l *Lookup) closestDistance() *KademliaID {
+	if l.shortlist.Len() == 0 {
+		return nil
+	}
+	distance := *l.shortlist.GetContacts(1)[0].distance
+	return &distance
+}
+
+// getUnqueriedContacts returns up to count contacts among the k closest on
+// the shortlist that have not been queried, and marks them as queried.
+// Contacts already claimed by another path of a disjoint lookup are dropped.
+func (l *Lookup) getUnqueriedContacts(count int) []Contact {
+	for {
+		var contacts, taken []Contact
+		for _, contact := range l.shortlist.GetContacts(l.config.K) {
+			if len(contacts) >= count {
+				break
+			}
+			if l.queried[*contact.ID] {
+				continue
+			}
+			if l.claim != nil && !l.claim(contact.ID) {
+				taken = append(taken, contact)
+				continue
+			}
+			l.queried[*contact.ID] = true
 			contacts = append(contacts, contact)
 		}
+		for _, contact := range taken {
+			l.shortlist.Remove(contact.ID)
+		}
+		// Dropping contacts may have moved unqueried ones into the k closest.
+		if len(contacts) > 0 || len(taken) == 0 {
+			return contacts
+		}
 	}
-	return contacts
 }
 
-func (l *Lookup) queryContacts(contacts []Contact) []Contact {
-	var newContacts []Contact
-	var wg sync.WaitGroup
-	resultsChan := make(chan []Contact, len(contacts))
+// queryResult is the outcome of querying a single contact.
+type queryResult struct {
+	contact  Contact
+	contacts []Contact
+	value    []byte
+	err      error
+}
+
+// queryContacts queries contacts in parallel as one round of the lookup and
+// adds the contacts they reported to the shortlist.
+func (l *Lookup) queryContacts(contacts []Contact) {
+	resultsChan := make(chan queryResult, len(contacts))
+	l.round++
+	l.emit(LookupEvent{Type: LookupRoundStarted, Contacts: contacts})
 
 	for _, contact := range contacts {
-		l.queried[*contact.ID] = true
-		wg.Add(1)
 		go func(c Contact) {
-			defer wg.Done()
-			// Make sure the contact has its distance calculated relative to the target
-			c.CalcDistance(l.target)
-			
-			foundContacts, err := l.rpc.FindNode(&c, l.target)
-			if err != nil {
+			if l.findValue {
+				value, foundContacts, err := l.rpc.FindValue(&c, l.target)
+				resultsChan <- queryResult{contact: c, contacts: foundContacts, value: value, err: err}
 				return
 			}
-			resultsChan <- foundContacts
+
+			foundContacts, err := l.rpc.FindNode(&c, l.target)
+			resultsChan <- queryResult{contact: c, contacts: foundContacts, err: err}
 		}(contact)
 	}
 
-	wg.Wait()
-	close(resultsChan)
+	for range contacts {
+		result := <-resultsChan
+		if result.err == nil && result.value != nil && !ValidValue(l.target, result.value) {
+			// Whatever else the contact returned cannot be trusted either.
+			invalidValues.Inc()
+			l.routingTable.Penalize(result.contact.ID)
+			result.err = ErrInvalidValue
+		}
+		if result.err != nil {
+			l.shortlist.Remove(result.contact.ID)
+			l.emit(LookupEvent{Type: LookupFailed, Contact: &result.contact, Err: result.err})
+			continue
+		}
+		l.emit(LookupEvent{Type: LookupResponse, Contact: &result.contact, Contacts: result.contacts, Value: result.value != nil})
+
+		if result.value != nil && l.value == nil {
+			l.value = result.value
+		}
+		if l.findValue && result.value == nil && (l.missed == nil || result.contact.Less(l.missed)) {
+			missed := result.contact
+			l.missed = &missed
+		}
 
-	for contacts := range resultsChan {
-		newContacts = append(newContacts, contacts...)
+		if discovered := l.add(result.contacts); len(discovered) > 0 {
+			l.emit(LookupEvent{Type: LookupDiscovered, Contact: &result.contact, Contacts: discovered})
+		}
 	}
-
-	return newContacts
 }
@@ -0,0 +1,317 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// simNetwork is an in-process network of routing tables used to simulate lookups.
+type simNetwork struct {
+	mutex sync.Mutex
+	nodes map[KademliaID]*RoutingTable
+	dead  map[KademliaID]bool
+}
+
+// newSimNetwork creates size nodes with random IDs. Every node learns about
+// peers random other nodes, which gives a connected but incomplete overlay.
+func newSimNetwork(size, peers int, seed int64) *simNetwork {
+	random := rand.New(rand.NewSource(seed))
+	sim := &simNetwork{
+		nodes: make(map[KademliaID]*RoutingTable),
+		dead:  make(map[KademliaID]bool),
+	}
+
+	var contacts []Contact
+	for i := 0; i < size; i++ {
+		id := KademliaID{}
+		random.Read(id[:])
+		contact := NewContact(&id, fmt.Sprintf("sim-%d", i))
+		contacts = append(contacts, contact)
+		sim.nodes[id] = NewRoutingTable(contact, DefaultConfig())
+	}
+	for i, contact := range contacts {
+		rt := sim.nodes[*contact.ID]
+		// Link each node to the next one so the overlay is connected.
+		rt.AddContact(contacts[(i+1)%size], sim.rpc())
+		for j := 0; j < peers; j++ {
+			if peer := contacts[random.Intn(size)]; !peer.ID.Equals(contact.ID) {
+				rt.AddContact(peer, sim.rpc())
+			}
+		}
+	}
+	return sim
+}
+
+// rpc returns an RPC that answers on behalf of the simulated nodes.
+func (sim *simNetwork) rpc() *simRPC {
+	return &simRPC{sim: sim}
+}
+
+// kill makes a node stop answering.
+func (sim *simNetwork) kill(id *KademliaID) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	sim.dead[*id] = true
+}
+
+// node returns the routing table of a live node, or an error if it is dead or unknown.
+func (sim *simNetwork) node(id *KademliaID) (*RoutingTable, error) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	rt, ok := sim.nodes[*id]
+	if !ok || sim.dead[*id] {
+		return nil, errors.New("rpc timeout")
+	}
+	return rt, nil
+}
+
+// simRPC implements RPC on top of a simNetwork.
+type simRPC struct {
+	sim   *simNetwork
+	mutex sync.Mutex
+	calls int
+}
+
+func (r *simRPC) FindNode(contact *Contact, target *KademliaID) ([]Contact, error) {
+	r.mutex.Lock()
+	r.calls++
+	r.mutex.Unlock()
+
+	rt, err := r.sim.node(contact.ID)
+	if err != nil {
+		return nil, err
+	}
+	return rt.FindClosestContacts(target, rt.Config().K), nil
+}
+
+func (r *simRPC) Ping(contact *Contact) error {
+	_, err := r.sim.node(contact.ID)
+	return err
+}
+
+func (r *simRPC) Store(contact *Contact, key *KademliaID, value []byte, publications []Publication) error {
+	_, err := r.sim.node(contact.ID)
+	return err
+}
+
+func (r *simRPC) StoreRecord(contact *Contact, record *Record) error {
+	_, err := r.sim.node(contact.ID)
+	return err
+}
+
+func (r *simRPC) Delete(contact *Contact, tombstone *Tombstone) error {
+	_, err := r.sim.node(contact.ID)
+	return err
+}
+
+func (r *simRPC) Cache(contact *Contact, key *KademliaID, value []byte) error {
+	_, err := r.sim.node(contact.ID)
+	return err
+}
+
+func (r *simRPC) FindValue(contact *Contact, key *KademliaID) ([]byte, []Contact, error) {
+	contacts, err := r.FindNode(contact, key)
+	return nil, contacts, err
+}
+
+func TestLookupTraceRecordsEvents(t *testing.T) {
+	sim := newSimNetwork(100, 5, 1)
+	var origin *RoutingTable
+	for _, rt := range sim.nodes {
+		origin = rt
+		break
+	}
+	// Kill one of the nodes the lookup starts with so a failure shows up.
+	target := NewRandomKademliaID()
+	initial := origin.FindClosestContacts(target, DefaultConfig().Alpha)
+	sim.kill(initial[0].ID)
+
+	recorder := NewTraceRecorder(target)
+	var events []LookupEventType
+	lookup := NewLookup(origin, sim.rpc(), target, DefaultConfig())
+	lookup.SetObserver(func(event LookupEvent) {
+		events = append(events, event.Type)
+		recorder.Observe(event)
+	})
+	result := lookup.Start()
+
+	if events[0] != LookupStarted || events[len(events)-1] != LookupFinished {
+		t.Errorf("Expected events to start with started and end with finished, got %v", events)
+	}
+
+	trace := recorder.Trace()
+	if len(trace.Rounds) == 0 {
+		t.Fatal("Expected at least one round in the trace")
+	}
+	if len(trace.Result) != len(result) {
+		t.Errorf("Expected %d contacts in the trace result but got %d", len(result), len(trace.Result))
+	}
+	if trace.Reason == "" {
+		t.Error("Expected a termination reason")
+	}
+	failures := 0
+	for _, round := range trace.Rounds {
+		failures += len(round.Failures)
+	}
+	if failures == 0 {
+		t.Error("Expected the dead contact to show up as a failure")
+	}
+
+	dot := recorder.DOT()
+	if !strings.HasPrefix(dot, "digraph lookup {") || !strings.Contains(dot, "->") {
+		t.Errorf("Unexpected DOT output:\n%s", dot)
+	}
+	if _, err := recorder.JSON(); err != nil {
+		t.Errorf("Failed to encode trace as JSON: %v", err)
+	}
+}
+
+// closestLive returns the count live nodes of the simulation closest to target, excluding exclude.
+func (sim *simNetwork) closestLive(target, exclude *KademliaID, count int) []Contact {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	candidates := &ContactCandidates{}
+	for id, rt := range sim.nodes {
+		if sim.dead[id] || id == *exclude {
+			continue
+		}
+		contact := rt.Me()
+		contact.CalcDistance(target)
+		candidates.Append([]Contact{contact})
+	}
+	candidates.Sort()
+	return candidates.GetContacts(count)
+}
+
+func TestLookupConvergesToClosestNodes(t *testing.T) {
+	// Every node tries to learn about most others, so the routing tables are
+	// only limited by the bucket size, like in a long running network.
+	sim := newSimNetwork(300, 300, 2)
+	random := rand.New(rand.NewSource(3))
+	var ids []KademliaID
+	for id := range sim.nodes {
+		ids = append(ids, id)
+	}
+	// Kill a few nodes to make sure failures do not end up in the result.
+	for i := 0; i < 15; i++ {
+		sim.kill(&ids[random.Intn(len(ids))])
+	}
+
+	config := DefaultConfig()
+	for i := 0; i < 20; i++ {
+		origin := sim.nodes[ids[random.Intn(len(ids))]]
+		if _, err := sim.node(origin.Me().ID); err != nil {
+			continue
+		}
+		target := KademliaID{}
+		random.Read(target[:])
+
+		result := NewLookup(origin, sim.rpc(), &target, config).Start()
+		expected := sim.closestLive(&target, origin.Me().ID, config.K)
+		if len(result) != len(expected) {
+			t.Fatalf("Lookup %d: expected %d contacts but got %d", i, len(expected), len(result))
+		}
+		for j := range expected {
+			if !result[j].ID.Equals(expected[j].ID) {
+				t.Fatalf("Lookup %d: contact %d is %s, expected %s", i, j, result[j].ID, expected[j].ID)
+			}
+		}
+	}
+}
+
+func TestLookupQueriesEachContactOnce(t *testing.T) {
+	sim := newSimNetwork(100, 10, 4)
+	var origin *RoutingTable
+	for _, rt := range sim.nodes {
+		origin = rt
+		break
+	}
+
+	queried := make(map[KademliaID]int)
+	lookup := NewLookup(origin, sim.rpc(), NewRandomKademliaID(), DefaultConfig())
+	lookup.SetObserver(func(event LookupEvent) {
+		if event.Type == LookupRoundStarted {
+			for _, contact := range event.Contacts {
+				queried[*contact.ID]++
+			}
+		}
+	})
+	result := lookup.Start()
+
+	for id, count := range queried {
+		if count > 1 {
+			t.Errorf("Contact %s was queried %d times", id.String(), count)
+		}
+	}
+	if queried[*origin.Me().ID] > 0 {
+		t.Error("The lookup queried its own node")
+	}
+	seen := make(map[KademliaID]bool)
+	for _, contact := range result {
+		if seen[*contact.ID] {
+			t.Errorf("Contact %s appears twice in the result", contact.ID)
+		}
+		seen[*contact.ID] = true
+	}
+}
+
+// valueRPC makes holders answer FIND_VALUE with the value and corrupters with garbage.
+type valueRPC struct {
+	*simRPC
+	value      []byte
+	holders    map[KademliaID]bool
+	corrupters map[KademliaID]bool
+}
+
+func (r *valueRPC) FindValue(contact *Contact, key *KademliaID) ([]byte, []Contact, error) {
+	switch {
+	case r.corrupters[*contact.ID]:
+		contacts, err := r.simRPC.FindNode(contact, key)
+		return []byte("corrupted"), contacts, err
+	case r.holders[*contact.ID]:
+		return r.value, nil, nil
+	}
+	return r.simRPC.FindValue(contact, key)
+}
+
+func TestValueLookupRejectsCorruptedValues(t *testing.T) {
+	sim := newSimNetwork(200, 200, 5)
+	var origin *RoutingTable
+	for _, rt := range sim.nodes {
+		origin = rt
+		break
+	}
+	value := []byte("the real value")
+	key := NewKademliaIDFromData(value)
+
+	// The contacts queried first all return garbage, the k closest hold the value.
+	rpc := &valueRPC{simRPC: sim.rpc(), value: value, holders: make(map[KademliaID]bool), corrupters: make(map[KademliaID]bool)}
+	corrupters := origin.FindClosestContacts(key, DefaultConfig().Alpha)
+	for _, contact := range corrupters {
+		rpc.corrupters[*contact.ID] = true
+	}
+	for _, contact := range sim.closestLive(key, origin.Me().ID, DefaultConfig().K) {
+		rpc.holders[*contact.ID] = true
+	}
+
+	lookup := NewValueLookup(origin, rpc, key, DefaultConfig())
+	result := lookup.Start()
+	if string(lookup.Value()) != string(value) {
+		t.Fatalf("Expected %q but got %q", value, lookup.Value())
+	}
+	for _, contact := range result {
+		if rpc.corrupters[*contact.ID] {
+			t.Errorf("Expected %s to be dropped from the result", contact.ID)
+		}
+	}
+	for _, corrupter := range corrupters {
+		contacts := origin.FindClosestContacts(corrupter.ID, 1)
+		if len(contacts) != 1 || contacts[0].Reputation() != -1 {
+			t.Errorf("Expected %s to be penalized once, got %v", corrupter.ID, contacts)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// pkg/dht/metrics.go
+package dht
+
+import (
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/metrics"
+)
+
+var (
+	lookupRounds      = metrics.NewHistogram("kademlia_lookup_hops", "Rounds of queries needed by an iterative lookup.", []float64{1, 2, 3, 4, 5, 6, 8, 10, 15, 20})
+	lookupDuration    = metrics.NewHistogram("kademlia_lookup_duration_seconds", "Duration of an iterative lookup.", metrics.DefaultBuckets)
+	routingEvictions  = metrics.NewCounter("kademlia_routing_evictions_total", "Contacts evicted from a full k-bucket after failing to answer a ping, or after losing their reputation.")
+	routingRejections = metrics.NewCounterVec("kademlia_routing_rejected_total", "Contacts not added to the routing table, by reason: address_limit when their IP address or subnet is over its limit, distrusted when they were evicted for their reputation.", "reason")
+	handOffs          = metrics.NewCounter("kademlia_handoff_values_total", "Values sent to newly discovered nodes that are closer to their keys.")
+	pathCaches        = metrics.NewCounter("kademlia_cached_values_total", "Values found by a lookup and cached on the closest node that did not have them.")
+	invalidValues     = metrics.NewCounter("kademlia_invalid_values_total", "Values returned by FIND_VALUE that did not match the requested key.")
+	storeRejections   = metrics.NewCounter("kademlia_store_rejected_total", "Values not stored because they did not fit into the storage quota.")
+	storeEvictions    = metrics.NewCounter("kademlia_store_evictions_total", "Values evicted from the store to make room for new ones.")
+)
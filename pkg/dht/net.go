@@ -7,4 +7,17 @@ type RPC interface {
 	FindNode(contact *Contact, target *KademliaID) ([]Contact, error)
 	// Ping sends a PING request to a contact and expects a PONG in return.
 	Ping(contact *Contact) error
+	// Store sends a STORE request asking a contact to keep value under key,
+	// attributed to its publishers by publications, if known.
+	Store(contact *Contact, key *KademliaID, value []byte, publications []Publication) error
+	// FindValue sends a FIND_VALUE request to a contact. It returns the value if the
+	// contact has it, and a list of closer contacts otherwise.
+	FindValue(contact *Contact, key *KademliaID) ([]byte, []Contact, error)
+	// StoreRecord sends a STORE_RECORD request asking a contact to keep a signed record.
+	StoreRecord(contact *Contact, record *Record) error
+	// Delete sends a DELETE request asking a contact to drop a value and keep the tombstone.
+	Delete(contact *Contact, tombstone *Tombstone) error
+	// Cache sends a STORE request asking a contact to cache a value found by a
+	// lookup, for a time scaled by how far the contact is from key.
+	Cache(contact *Contact, key *KademliaID, value []byte) error
 }
@@ -0,0 +1,311 @@
+// pkg/dht/object.go
+package dht
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ChunkSize is the size of the chunks large objects are split into. After the
+// value is encoded into a STORE or FIND_VALUE message, a chunk still fits into
+// a single UDP datagram.
+const ChunkSize = 32 << 10
+
+// MaxChunks bounds the number of chunks in an object, so that its manifest
+// fits into a single chunk as well.
+const MaxChunks = 640
+
+// objectParallelism is how many chunks PutObject and GetObject transfer at once.
+const objectParallelism = 8
+
+var (
+	// ErrEmptyObject is returned by PutObject when there is nothing to store.
+	ErrEmptyObject = errors.New("empty object")
+	// ErrObjectTooLarge is returned by PutObject for objects of more than MaxChunks chunks.
+	ErrObjectTooLarge = fmt.Errorf("object is larger than %d bytes", MaxChunks*ChunkSize)
+)
+
+// Manifest describes an object that was split into chunks. It is stored under
+// its Merkle root, and each chunk under its own content hash.
+type Manifest struct {
+	Size   int64
+	Chunks []KademliaID
+	Root   KademliaID
+}
+
+// manifestJSON is the encoding of a Manifest. Hashes are hex strings rather
+// than the byte arrays KademliaIDs are encoded as in messages, which keeps the
+// manifest of MaxChunks chunks within ChunkSize.
+type manifestJSON struct {
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"`
+	Root   string   `json:"root"`
+}
+
+// MarshalJSON encodes the manifest with hex encoded hashes.
+func (manifest Manifest) MarshalJSON() ([]byte, error) {
+	encoded := manifestJSON{Size: manifest.Size, Root: manifest.Root.String()}
+	for _, chunk := range manifest.Chunks {
+		encoded.Chunks = append(encoded.Chunks, chunk.String())
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes a manifest encoded by MarshalJSON.
+func (manifest *Manifest) UnmarshalJSON(data []byte) error {
+	var encoded manifestJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	root, err := ParseKademliaID(encoded.Root)
+	if err != nil {
+		return fmt.Errorf("root: %w", err)
+	}
+	chunks := make([]KademliaID, len(encoded.Chunks))
+	for i, chunk := range encoded.Chunks {
+		id, err := ParseKademliaID(chunk)
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
+		}
+		chunks[i] = *id
+	}
+	*manifest = Manifest{Size: encoded.Size, Chunks: chunks, Root: *root}
+	return nil
+}
+
+// MerkleRoot returns the root of the Merkle tree over the chunk hashes. Leaves
+// and inner nodes are hashed with different prefixes, so the root never equals
+// the content hash of a chunk and no inner node can pose as a leaf.
+func MerkleRoot(chunks []KademliaID) *KademliaID {
+	if len(chunks) == 0 {
+		return NewKademliaIDFromData(nil)
+	}
+	level := make([]KademliaID, len(chunks))
+	for i, chunk := range chunks {
+		level[i] = sha1.Sum(append([]byte{0}, chunk[:]...))
+	}
+	for len(level) > 1 {
+		var next []KademliaID
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				// An odd node is promoted to the next level unchanged.
+				next = append(next, level[i])
+				continue
+			}
+			node := append([]byte{1}, level[i][:]...)
+			next = append(next, sha1.Sum(append(node, level[i+1][:]...)))
+		}
+		level = next
+	}
+	return &level[0]
+}
+
+// DecodeManifest parses the manifest stored under root and checks that it
+// matches its Merkle root.
+func DecodeManifest(root *KademliaID, data []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	chunks := int64(len(manifest.Chunks))
+	if chunks < 2 || chunks > MaxChunks {
+		return nil, fmt.Errorf("invalid manifest: %d chunks", chunks)
+	}
+	if !manifest.Root.Equals(root) || !MerkleRoot(manifest.Chunks).Equals(root) {
+		return nil, errors.New("manifest does not match its Merkle root")
+	}
+	if manifest.Size <= (chunks-1)*ChunkSize || manifest.Size > chunks*ChunkSize {
+		return nil, fmt.Errorf("invalid manifest: %d bytes in %d chunks", manifest.Size, chunks)
+	}
+	return &manifest, nil
+}
+
+// PutObject reads r to the end and stores it on the network. An object that
+// fits into one chunk is stored like Put does, under its content hash. Larger
+// objects are split into chunks of ChunkSize, each stored under its content
+// hash, and a Manifest is stored under their Merkle root. The returned key is
+// the one GetObject expects. Chunks and manifest are republished like values
+// passed to Put, but only once every chunk is stored. If PutObject fails
+// before that, nothing is left to republish.
+func (k *Kademlia) PutObject(r io.Reader) (*KademliaID, error) {
+	var chunks []KademliaID
+	var size int64
+	// The first chunk is held back until it is clear that there is a second one.
+	var first []byte
+	uploads := &chunkUploads{
+		kademlia:  k,
+		semaphore: make(chan struct{}, objectParallelism),
+		uploaded:  make(map[KademliaID][]byte),
+	}
+
+	for {
+		chunk := make([]byte, ChunkSize)
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			if len(chunks) == MaxChunks {
+				uploads.wait()
+				return nil, ErrObjectTooLarge
+			}
+			chunk = chunk[:n]
+			chunks = append(chunks, *NewKademliaIDFromData(chunk))
+			size += int64(n)
+			if len(chunks) == 1 {
+				first = chunk
+			} else {
+				if first != nil {
+					uploads.put(first)
+					first = nil
+				}
+				uploads.put(chunk)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			uploads.wait()
+			return nil, err
+		}
+	}
+
+	switch len(chunks) {
+	case 0:
+		return nil, ErrEmptyObject
+	case 1:
+		return k.Put(first)
+	}
+	if err := uploads.wait(); err != nil {
+		return nil, err
+	}
+
+	manifest := Manifest{Size: size, Chunks: chunks, Root: *MerkleRoot(chunks)}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	k.mutex.Lock()
+	for key, chunk := range uploads.uploaded {
+		k.published[key] = chunk
+	}
+	k.published[manifest.Root] = data
+	k.mutex.Unlock()
+	return &manifest.Root, k.publish(&manifest.Root, data)
+}
+
+// GetObject writes the object stored under key by PutObject to w. Chunks are
+// fetched in parallel and each is checked against its hash in the manifest.
+func (k *Kademlia) GetObject(key *KademliaID, w io.Writer) error {
+	data, err := k.Get(key)
+	if err != nil {
+		return err
+	}
+	if NewKademliaIDFromData(data).Equals(key) {
+		_, err := w.Write(data)
+		return err
+	}
+	manifest, err := DecodeManifest(key, data)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make([]chan result, len(manifest.Chunks))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	// The semaphore is released once a chunk is written, which bounds the
+	// number of chunks held in memory as well as the number of fetches.
+	semaphore := make(chan struct{}, objectParallelism)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := range manifest.Chunks {
+			select {
+			case semaphore <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func() {
+				data, err := k.getChunk(&manifest.Chunks[i])
+				results[i] <- result{data, err}
+			}()
+		}
+	}()
+
+	var written int64
+	for i := range manifest.Chunks {
+		chunk := <-results[i]
+		<-semaphore
+		if chunk.err != nil {
+			return fmt.Errorf("chunk %d: %w", i, chunk.err)
+		}
+		if i < len(manifest.Chunks)-1 && len(chunk.data) != ChunkSize {
+			return fmt.Errorf("chunk %d: expected %d bytes but got %d", i, ChunkSize, len(chunk.data))
+		}
+		if _, err := w.Write(chunk.data); err != nil {
+			return err
+		}
+		written += int64(len(chunk.data))
+	}
+	if written != manifest.Size {
+		return fmt.Errorf("expected %d bytes but got %d", manifest.Size, written)
+	}
+	return nil
+}
+
+// getChunk returns the chunk stored under key after checking it against its hash.
+func (k *Kademlia) getChunk(key *KademliaID) ([]byte, error) {
+	data, err := k.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !NewKademliaIDFromData(data).Equals(key) {
+		return nil, errors.New("chunk does not match its hash")
+	}
+	return data, nil
+}
+
+// chunkUploads stores chunks in parallel and remembers the first error. The
+// chunks are not registered for republishing, PutObject does that once the
+// whole object is stored.
+type chunkUploads struct {
+	kademlia  *Kademlia
+	semaphore chan struct{}
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+	uploaded  map[KademliaID][]byte
+	err       error
+}
+
+// put starts storing chunk, blocking while too many uploads are in flight.
+func (uploads *chunkUploads) put(chunk []byte) {
+	uploads.semaphore <- struct{}{}
+	uploads.wg.Add(1)
+	go func() {
+		defer func() {
+			<-uploads.semaphore
+			uploads.wg.Done()
+		}()
+		key := NewKademliaIDFromData(chunk)
+		err := uploads.kademlia.publish(key, chunk)
+		uploads.mutex.Lock()
+		defer uploads.mutex.Unlock()
+		if err != nil && uploads.err == nil {
+			uploads.err = err
+		}
+		uploads.uploaded[*key] = chunk
+	}()
+}
+
+// wait waits for every upload to finish and returns the first error.
+func (uploads *chunkUploads) wait() error {
+	uploads.wg.Wait()
+	return uploads.err
+}
@@ -0,0 +1,129 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	chunks := []KademliaID{
+		*NewKademliaIDFromData([]byte("a")),
+		*NewKademliaIDFromData([]byte("b")),
+		*NewKademliaIDFromData([]byte("c")),
+	}
+	root := MerkleRoot(chunks)
+	if !root.Equals(MerkleRoot(chunks)) {
+		t.Error("Expected the Merkle root to be deterministic")
+	}
+	if MerkleRoot(chunks[:1]).Equals(&chunks[0]) {
+		t.Error("Expected the root of a single chunk to differ from its hash")
+	}
+
+	swapped := []KademliaID{chunks[1], chunks[0], chunks[2]}
+	if root.Equals(MerkleRoot(swapped)) {
+		t.Error("Expected the Merkle root to depend on the chunk order")
+	}
+	if root.Equals(MerkleRoot(chunks[:2])) {
+		t.Error("Expected the Merkle root to depend on every chunk")
+	}
+}
+
+func TestDecodeManifest(t *testing.T) {
+	chunks := []KademliaID{
+		*NewKademliaIDFromData([]byte("a")),
+		*NewKademliaIDFromData([]byte("b")),
+	}
+	manifest := Manifest{Size: ChunkSize + 1, Chunks: chunks, Root: *MerkleRoot(chunks)}
+	encode := func(manifest Manifest) []byte {
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	decoded, err := DecodeManifest(&manifest.Root, encode(manifest))
+	if err != nil {
+		t.Fatalf("Expected the manifest to decode, got %v", err)
+	}
+	if decoded.Size != manifest.Size || len(decoded.Chunks) != 2 {
+		t.Errorf("Expected %+v but got %+v", manifest, decoded)
+	}
+
+	replaced := manifest
+	replaced.Chunks = []KademliaID{chunks[0], *NewKademliaIDFromData([]byte("evil"))}
+	if _, err := DecodeManifest(&manifest.Root, encode(replaced)); err == nil {
+		t.Error("Expected a manifest with a replaced chunk to be rejected")
+	}
+
+	wrongSize := manifest
+	wrongSize.Size = 3 * ChunkSize
+	if _, err := DecodeManifest(&manifest.Root, encode(wrongSize)); err == nil {
+		t.Error("Expected a manifest whose size does not fit its chunks to be rejected")
+	}
+}
+
+func TestLargestManifestFitsIntoAChunk(t *testing.T) {
+	chunks := make([]KademliaID, MaxChunks)
+	for i := range chunks {
+		chunks[i] = *NewRandomKademliaID()
+	}
+	manifest := Manifest{Size: MaxChunks * ChunkSize, Chunks: chunks, Root: *MerkleRoot(chunks)}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > ChunkSize {
+		t.Errorf("Expected the manifest to fit into %d bytes but it takes %d", ChunkSize, len(data))
+	}
+	if _, err := DecodeManifest(&manifest.Root, data); err != nil {
+		t.Errorf("Expected the manifest to decode, got %v", err)
+	}
+}
+
+// failingReader returns n bytes of data and then fails.
+type failingReader struct {
+	n int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, errors.New("read failed")
+	}
+	n := min(len(p), r.n)
+	r.n -= n
+	return n, nil
+}
+
+func TestFailedPutObjectLeavesNothingToRepublish(t *testing.T) {
+	me := NewContact(NewRandomKademliaID(), "localhost:8000")
+	kademlia := NewKademlia(NewRoutingTable(me, DefaultConfig()), &mockRPC{}, NewStore(), DefaultConfig())
+
+	for name, r := range map[string]io.Reader{
+		"oversized": bytes.NewReader(make([]byte, MaxChunks*ChunkSize+1)),
+		"failing":   &failingReader{n: 3 * ChunkSize},
+	} {
+		if _, err := kademlia.PutObject(r); err == nil {
+			t.Errorf("%s: expected PutObject to fail", name)
+		}
+		if len(kademlia.published) != 0 {
+			t.Errorf("%s: expected nothing to be republished, got %d values", name, len(kademlia.published))
+		}
+	}
+
+	data := bytes.Repeat([]byte("chunk"), ChunkSize)
+	root, err := kademlia.PutObject(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to store object: %v", err)
+	}
+	if len(kademlia.published) != 6 {
+		t.Errorf("Expected the manifest and 5 chunks to be republished, got %d values", len(kademlia.published))
+	}
+	kademlia.Forget(root)
+	if len(kademlia.published) != 0 {
+		t.Errorf("Expected Forget to drop the manifest and its chunks, got %d values", len(kademlia.published))
+	}
+}
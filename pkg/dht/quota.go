@@ -0,0 +1,46 @@
+// pkg/dht/quota.go
+package dht
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStoreFull is returned when a value does not fit into the Store's quota.
+var ErrStoreFull = errors.New("store is full")
+
+// EvictionPolicy decides which value makes room when the Store is full.
+type EvictionPolicy string
+
+const (
+	// EvictNothing rejects new values once the Store is full
+	EvictNothing EvictionPolicy = "reject"
+	// EvictFarthest evicts the value whose key is farthest from the node's own
+	// ID, the one it is least responsible for. A new value that would be the
+	// farthest is rejected instead.
+	EvictFarthest EvictionPolicy = "farthest"
+	// EvictLeastRecentlyUsed evicts the value that was stored or read the longest time ago
+	EvictLeastRecentlyUsed EvictionPolicy = "lru"
+	// EvictSoonestExpiry evicts the value closest to expiring
+	EvictSoonestExpiry EvictionPolicy = "expiry"
+)
+
+// Quota limits the values a Store holds. Zero limits mean no limit.
+type Quota struct {
+	MaxBytes int            `json:"max_bytes"`
+	MaxKeys  int            `json:"max_keys"`
+	Policy   EvictionPolicy `json:"policy"`
+}
+
+// Validate reports whether the quota can be used
+func (quota Quota) Validate() error {
+	if quota.MaxBytes < 0 || quota.MaxKeys < 0 {
+		return errors.New("storage limits must not be negative")
+	}
+	switch quota.Policy {
+	case EvictNothing, EvictFarthest, EvictLeastRecentlyUsed, EvictSoonestExpiry:
+		return nil
+	default:
+		return fmt.Errorf("unknown eviction policy %q, expected reject, farthest, lru or expiry", quota.Policy)
+	}
+}
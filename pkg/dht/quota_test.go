@@ -0,0 +1,86 @@
+package dht
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreQuotaPolicies(t *testing.T) {
+	self := NewKademliaID("0000000000000000000000000000000000000000")
+	near := NewKademliaID("0000000000000000000000000000000000000001")
+	middle := NewKademliaID("00000000000000000000000000000000000000F0")
+	far := NewKademliaID("F000000000000000000000000000000000000000")
+	farther := NewKademliaID("FF00000000000000000000000000000000000000")
+	closer := NewKademliaID("0000000000000000000000000000000000000002")
+
+	// Each store holds near, middle and far, with middle stored last, near
+	// expiring first and far read most recently.
+	fill := func(policy EvictionPolicy) *Store {
+		store := NewStore()
+		store.SetQuota(Quota{MaxKeys: 3, Policy: policy}, self)
+		for _, put := range []struct {
+			key *KademliaID
+			ttl time.Duration
+		}{{far, 3 * time.Hour}, {near, time.Hour}, {middle, 2 * time.Hour}} {
+			if err := store.Put(put.key, []byte("value"), put.ttl); err != nil {
+				t.Fatalf("Failed to fill store: %v", err)
+			}
+		}
+		store.Get(far)
+		return store
+	}
+
+	tests := []struct {
+		policy  EvictionPolicy
+		key     *KademliaID
+		evicted *KademliaID
+	}{
+		{EvictNothing, closer, nil},
+		{EvictFarthest, closer, far},
+		{EvictFarthest, farther, nil},
+		{EvictLeastRecentlyUsed, farther, near},
+		{EvictSoonestExpiry, farther, near},
+	}
+	for _, test := range tests {
+		store := fill(test.policy)
+		err := store.Put(test.key, []byte("value"), time.Hour)
+		if test.evicted == nil {
+			if !errors.Is(err, ErrStoreFull) {
+				t.Errorf("%s: expected the new value to be rejected, got %v", test.policy, err)
+			}
+			if store.Len() != 3 {
+				t.Errorf("%s: expected 3 values to remain, got %d", test.policy, store.Len())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected the new value to be stored, got %v", test.policy, err)
+			continue
+		}
+		if _, ok := store.Get(test.evicted); ok {
+			t.Errorf("%s: expected %s to be evicted", test.policy, test.evicted)
+		}
+		if store.Len() != 3 {
+			t.Errorf("%s: expected 3 values but got %d", test.policy, store.Len())
+		}
+	}
+}
+
+func TestStoreQuotaBytes(t *testing.T) {
+	store := NewStore()
+	store.SetQuota(Quota{MaxBytes: 10, Policy: EvictSoonestExpiry}, NewRandomKademliaID())
+
+	if err := store.Put(NewRandomKademliaID(), make([]byte, 11), time.Hour); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected a value larger than the quota to be rejected, got %v", err)
+	}
+	first := NewRandomKademliaID()
+	store.Put(first, make([]byte, 6), time.Hour)
+	store.Put(NewRandomKademliaID(), make([]byte, 6), 2*time.Hour)
+	if _, ok := store.Get(first); ok {
+		t.Error("Expected the first value to be evicted to make room")
+	}
+	if store.Bytes() != 6 {
+		t.Errorf("Expected 6 bytes stored but got %d", store.Bytes())
+	}
+}
@@ -0,0 +1,100 @@
+// pkg/dht/record.go
+package dht
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MaxRecordValue bounds the value of a record. A record is JSON encoded and
+// then encoded once or twice more inside STORE_RECORD and FIND_VALUE messages,
+// which must still fit into a single UDP datagram.
+const MaxRecordValue = 16 << 10
+
+// MaxRecordName bounds the name of a record.
+const MaxRecordName = 255
+
+var (
+	// ErrStaleRecord is returned when a record is not newer than the one already stored.
+	ErrStaleRecord = errors.New("record sequence is not newer than the stored one")
+	// ErrRecordTooLarge is returned for records whose name or value exceed MaxRecordName or MaxRecordValue.
+	ErrRecordTooLarge = fmt.Errorf("record name is longer than %d bytes or value larger than %d bytes", MaxRecordName, MaxRecordValue)
+)
+
+// Record is a mutable value stored under H(public key || name). Only the owner
+// of the key can sign new versions or tombstones, and nodes only replace a
+// record with one that has a higher sequence number.
+type Record struct {
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Name      string            `json:"name"`
+	Sequence  uint64            `json:"sequence"`
+	Value     []byte            `json:"value"`
+	Signature []byte            `json:"signature"`
+}
+
+// RecordKey returns the key a record named name by the owner of publicKey is stored under.
+func RecordKey(publicKey ed25519.PublicKey, name string) *KademliaID {
+	return NewKademliaIDFromData(append(append([]byte{}, publicKey...), name...))
+}
+
+// NewRecord returns a record signed by identity.
+func NewRecord(identity *Identity, name string, sequence uint64, value []byte) *Record {
+	record := &Record{
+		PublicKey: identity.PublicKey,
+		Name:      name,
+		Sequence:  sequence,
+		Value:     value,
+	}
+	record.Signature = identity.Sign(record.signedData())
+	return record
+}
+
+// Key returns the key the record is stored under.
+func (record *Record) Key() *KademliaID {
+	return RecordKey(record.PublicKey, record.Name)
+}
+
+// Verify checks the record's size and signature.
+func (record *Record) Verify() error {
+	if len(record.Name) > MaxRecordName || len(record.Value) > MaxRecordValue {
+		return ErrRecordTooLarge
+	}
+	if len(record.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	if !ed25519.Verify(record.PublicKey, record.signedData(), record.Signature) {
+		return errors.New("invalid record signature")
+	}
+	return nil
+}
+
+// signedData returns the bytes covered by the signature. Every field is length
+// prefixed so that no two records share them.
+func (record *Record) signedData() []byte {
+	data := []byte("kademlia-record")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(record.PublicKey)))
+	data = append(data, record.PublicKey...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(record.Name)))
+	data = append(data, record.Name...)
+	data = binary.BigEndian.AppendUint64(data, record.Sequence)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(record.Value)))
+	return append(data, record.Value...)
+}
+
+// DecodeRecord parses a record as returned by FIND_VALUE for key and verifies it.
+func DecodeRecord(key *KademliaID, data []byte) (*Record, error) {
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid record: %w", err)
+	}
+	if !record.Key().Equals(key) {
+		return nil, errors.New("record is not stored under its key")
+	}
+	if err := record.Verify(); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
@@ -0,0 +1,121 @@
+package dht
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStoreAcceptsOnlyNewerRecords(t *testing.T) {
+	identity, err := NewIdentity(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore()
+
+	first := NewRecord(identity, "profile", 1, []byte("first"))
+	if err := store.PutRecord(first, ValueTTL); err != nil {
+		t.Fatalf("Expected the first record to be accepted, got %v", err)
+	}
+	// Storing the same record again only refreshes it.
+	if err := store.PutRecord(first, ValueTTL); err != nil {
+		t.Errorf("Expected the same record to be accepted again, got %v", err)
+	}
+
+	second := NewRecord(identity, "profile", 2, []byte("second"))
+	if err := store.PutRecord(second, ValueTTL); err != nil {
+		t.Fatalf("Expected a newer record to be accepted, got %v", err)
+	}
+	if err := store.PutRecord(first, ValueTTL); !errors.Is(err, ErrStaleRecord) {
+		t.Errorf("Expected an older record to be rejected, got %v", err)
+	}
+	conflicting := NewRecord(identity, "profile", 2, []byte("conflicting"))
+	if err := store.PutRecord(conflicting, ValueTTL); !errors.Is(err, ErrStaleRecord) {
+		t.Errorf("Expected a different record with the same sequence to be rejected, got %v", err)
+	}
+
+	// Plain values do not replace records.
+	key := second.Key()
+	store.Put(key, []byte("overwrite"), ValueTTL)
+	data, ok := store.Get(key)
+	if !ok {
+		t.Fatal("Expected the record to be stored")
+	}
+	record, err := DecodeRecord(key, data)
+	if err != nil {
+		t.Fatalf("Failed to decode stored record: %v", err)
+	}
+	if record.Sequence != 2 || string(record.Value) != "second" {
+		t.Errorf("Expected the second record, got sequence %d with %q", record.Sequence, record.Value)
+	}
+}
+
+func TestStoreRejectsForgedRecords(t *testing.T) {
+	owner, err := NewIdentity(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attacker, err := NewIdentity(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore()
+
+	tampered := NewRecord(owner, "profile", 1, []byte("original"))
+	tampered.Value = []byte("tampered")
+	if err := store.PutRecord(tampered, ValueTTL); err == nil {
+		t.Error("Expected a record with a modified value to be rejected")
+	}
+
+	// A record signed by someone else cannot claim the owner's public key.
+	forged := NewRecord(attacker, "profile", 5, []byte("forged"))
+	forged.PublicKey = owner.PublicKey
+	if err := store.PutRecord(forged, ValueTTL); err == nil {
+		t.Error("Expected a record signed by another key to be rejected")
+	}
+	if store.Len() != 0 {
+		t.Errorf("Expected nothing to be stored, got %d values", store.Len())
+	}
+}
+
+func TestDecodeRecordChecksKey(t *testing.T) {
+	identity, err := NewIdentity(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := NewRecord(identity, "profile", 1, []byte("value"))
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeRecord(record.Key(), data); err != nil {
+		t.Errorf("Expected the record to decode, got %v", err)
+	}
+	if _, err := DecodeRecord(RecordKey(identity.PublicKey, "other"), data); err == nil {
+		t.Error("Expected a record under the wrong key to be rejected")
+	}
+}
+
+func TestOversizedRecordsAreRejected(t *testing.T) {
+	identity, err := NewIdentity(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore()
+	for _, record := range []*Record{
+		NewRecord(identity, "large", 1, make([]byte, MaxRecordValue+1)),
+		NewRecord(identity, strings.Repeat("n", MaxRecordName+1), 1, []byte("value")),
+	} {
+		if err := store.PutRecord(record, ValueTTL); !errors.Is(err, ErrRecordTooLarge) {
+			t.Errorf("Expected the record to be rejected as too large, got %v", err)
+		}
+	}
+	if store.Len() != 0 {
+		t.Errorf("Expected nothing to be stored, got %d values", store.Len())
+	}
+	if err := store.PutRecord(NewRecord(identity, "largest", 1, make([]byte, MaxRecordValue)), ValueTTL); err != nil {
+		t.Errorf("Expected a record of MaxRecordValue bytes to be stored, got %v", err)
+	}
+}
@@ -1,32 +1,122 @@
 // pkg/dht/routingtable.go
 package dht
 
-const BucketSize = 20
+import (
+	"math"
+	"sync"
+	"time"
+)
 
-const bucketSize = 20
+// DistrustTTL is how long a contact evicted for its reputation is refused.
+const DistrustTTL = time.Hour
+
+// maxDistrusted bounds the number of distrusted IDs a RoutingTable remembers.
+// Beyond it, the IDs closest to being trusted again are forgotten first.
+const maxDistrusted = 1024
 
 // RoutingTable definition
-// keeps a reference contact of me and an array of buckets
+// keeps a reference contact of me, an array of buckets and until when the
+// IDs of contacts evicted for their reputation are refused
 type RoutingTable struct {
-	me      Contact
-	buckets [IDLength * 8]*bucket
+	me          Contact
+	config      Config
+	buckets     [IDLength * 8]*bucket
+	subscribers subscribers
+	mutex       sync.RWMutex
+	distrusted  map[KademliaID]time.Time
 }
 
-// NewRoutingTable returns a new instance of a RoutingTable
-func NewRoutingTable(me Contact) *RoutingTable {
+// NewRoutingTable returns a new instance of a RoutingTable with buckets of config.K contacts
+func NewRoutingTable(me Contact, config Config) *RoutingTable {
 	routingTable := &RoutingTable{}
+	table := newAddressLimits(config.TableIPLimit, config.TableSubnetLimit)
 	for i := 0; i < IDLength*8; i++ {
-		routingTable.buckets[i] = newBucket()
+		routingTable.buckets[i] = newBucket(config.K)
+		routingTable.buckets[i].limits = newAddressLimits(config.BucketIPLimit, config.BucketSubnetLimit)
+		routingTable.buckets[i].table = table
 	}
 	routingTable.me = me
+	routingTable.config = config
+	routingTable.distrusted = make(map[KademliaID]time.Time)
 	return routingTable
 }
 
-// AddContact add a new contact to the correct Bucket
+// Config returns the parameters the RoutingTable was created with
+func (routingTable *RoutingTable) Config() Config {
+	return routingTable.config
+}
+
+// AddContact add a new contact to the correct Bucket, unless it was evicted
+// for its reputation less than DistrustTTL ago
 func (routingTable *RoutingTable) AddContact(contact Contact, rpc RPC) {
 	bucketIndex := routingTable.getBucketIndex(contact.ID)
+	if routingTable.distrusts(contact.ID) {
+		routingRejections.WithLabelValues("distrusted").Inc()
+		routingTable.subscribers.publish([]RoutingEvent{{Type: ContactRejected, Contact: contact, Bucket: bucketIndex}})
+		return
+	}
+
 	bucket := routingTable.buckets[bucketIndex]
-	bucket.AddContact(contact, rpc)
+	events := bucket.AddContact(contact, rpc)
+	for i := range events {
+		events[i].Bucket = bucketIndex
+	}
+	routingTable.subscribers.publish(events)
+}
+
+// Penalize lowers the reputation of the contact with the given id, for example
+// after it returned a corrupted value. Once it reaches MinReputation the
+// contact is evicted and is not added again for DistrustTTL.
+func (routingTable *RoutingTable) Penalize(id *KademliaID) {
+	bucketIndex := routingTable.getBucketIndex(id)
+	events := routingTable.buckets[bucketIndex].penalize(id)
+	if len(events) == 0 {
+		return
+	}
+	routingTable.distrust(id)
+	for i := range events {
+		events[i].Bucket = bucketIndex
+	}
+	routingTable.subscribers.publish(events)
+}
+
+// distrust refuses id for DistrustTTL. When maxDistrusted IDs are refused
+// already, the expired ones are dropped, or else the one expiring soonest.
+func (routingTable *RoutingTable) distrust(id *KademliaID) {
+	routingTable.mutex.Lock()
+	defer routingTable.mutex.Unlock()
+
+	now := time.Now()
+	if _, ok := routingTable.distrusted[*id]; !ok && len(routingTable.distrusted) >= maxDistrusted {
+		var soonest KademliaID
+		var soonestUntil time.Time
+		for distrusted, until := range routingTable.distrusted {
+			if now.After(until) {
+				delete(routingTable.distrusted, distrusted)
+			} else if soonestUntil.IsZero() || until.Before(soonestUntil) {
+				soonest, soonestUntil = distrusted, until
+			}
+		}
+		if len(routingTable.distrusted) >= maxDistrusted {
+			delete(routingTable.distrusted, soonest)
+		}
+	}
+	routingTable.distrusted[*id] = now.Add(DistrustTTL)
+}
+
+// distrusts reports whether id is still refused. Expired IDs are only
+// removed by distrust once the map is full.
+func (routingTable *RoutingTable) distrusts(id *KademliaID) bool {
+	routingTable.mutex.RLock()
+	defer routingTable.mutex.RUnlock()
+	until, ok := routingTable.distrusted[*id]
+	return ok && time.Now().Before(until)
+}
+
+// Subscribe registers handler to be called for every change to the RoutingTable.
+// The returned function cancels the subscription.
+func (routingTable *RoutingTable) Subscribe(handler RoutingHandler) (unsubscribe func()) {
+	return routingTable.subscribers.add(handler)
 }
 
 // FindClosestContacts finds the count closest Contacts to the target in the RoutingTable
@@ -57,6 +147,55 @@ func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count
 	return candidates.GetContacts(count)
 }
 
+// CountCloser returns the number of nodes that are closer to key than the
+// node that owns the RoutingTable. All of them fall into a single bucket,
+// which holds at most k, so beyond its own neighbourhood the number is
+// estimated: the k closest contacts to the node span a distance that holds
+// about k nodes, and the distance to key holds proportionally more.
+func (routingTable *RoutingTable) CountCloser(key *KademliaID) int {
+	own := routingTable.me.ID.CalcDistance(key)
+	count := 0
+	for _, bucket := range routingTable.buckets {
+		for _, contact := range bucket.Contacts() {
+			if contact.ID.CalcDistance(key).Less(own) {
+				count++
+			}
+		}
+	}
+
+	k := routingTable.config.K
+	neighbours := routingTable.FindClosestContacts(routingTable.me.ID, k)
+	if len(neighbours) < k {
+		return count
+	}
+	span := neighbours[k-1].ID.CalcDistance(routingTable.me.ID).float()
+	if span == 0 {
+		return count
+	}
+	estimate := min(float64(k)*own.float()/span, math.MaxInt32)
+	return max(count, int(estimate))
+}
+
+// Contains reports whether the routing table holds a contact with the same ID
+// and address as contact
+func (routingTable *RoutingTable) Contains(contact Contact) bool {
+	return routingTable.buckets[routingTable.getBucketIndex(contact.ID)].contains(contact)
+}
+
+// Me returns the contact of the node that owns the RoutingTable
+func (routingTable *RoutingTable) Me() Contact {
+	return routingTable.me
+}
+
+// Buckets returns a snapshot of the contacts in every bucket, indexed by bucket
+func (routingTable *RoutingTable) Buckets() [][]Contact {
+	buckets := make([][]Contact, len(routingTable.buckets))
+	for i, bucket := range routingTable.buckets {
+		buckets[i] = bucket.Contacts()
+	}
+	return buckets
+}
+
 // getBucketIndex get the correct Bucket index for the KademliaID
 func (routingTable *RoutingTable) getBucketIndex(id *KademliaID) int {
 	distance := id.CalcDistance(routingTable.me.ID)
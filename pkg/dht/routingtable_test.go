@@ -3,11 +3,12 @@ package dht
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestRoutingTable(t *testing.T) {
 	mockRPC := &mockRPC{pingShouldFail: false} // Assuming ping should succeed for this test
-	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"))
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"), DefaultConfig())
 
 	rt.AddContact(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8001"), mockRPC)
 	rt.AddContact(NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8002"), mockRPC)
@@ -27,3 +28,137 @@ func TestRoutingTable(t *testing.T) {
 }
 
 
+
+func TestRoutingTableEvents(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID("0000000000000000000000000000000000000000"), "localhost:8000"), DefaultConfig())
+	var events []RoutingEvent
+	unsubscribe := rt.Subscribe(func(event RoutingEvent) {
+		events = append(events, event)
+	})
+
+	// All of these IDs have the first bit set, so they land in bucket 0.
+	inBucketZero := func() Contact {
+		id := NewRandomKademliaID()
+		id[0] |= 0x80
+		return NewContact(id, "")
+	}
+
+	first := inBucketZero()
+	rt.AddContact(first, &mockRPC{})
+	rt.AddContact(first, &mockRPC{})
+	if len(events) != 2 || events[0].Type != ContactAdded || events[1].Type != ContactRefreshed || events[0].Bucket != 0 {
+		t.Fatalf("Expected added then refreshed in bucket 0, got %+v", events)
+	}
+
+	for i := 1; i < DefaultConfig().K; i++ {
+		rt.AddContact(inBucketZero(), &mockRPC{})
+	}
+	events = nil
+	newcomer := inBucketZero()
+	rt.AddContact(newcomer, &mockRPC{pingShouldFail: true})
+
+	var types []RoutingEventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	if len(types) != 3 || types[0] != BucketFull || types[1] != ContactEvicted || types[2] != ContactAdded {
+		t.Fatalf("Expected bucket full, evicted, added but got %v", types)
+	}
+	if !events[1].Contact.ID.Equals(first.ID) || !events[2].Contact.ID.Equals(newcomer.ID) {
+		t.Error("Expected the oldest contact to be evicted in favour of the newcomer")
+	}
+
+	unsubscribe()
+	events = nil
+	rt.AddContact(inBucketZero(), &mockRPC{})
+	if len(events) != 0 {
+		t.Errorf("Expected no events after unsubscribing, got %+v", events)
+	}
+}
+
+func TestRoutingTableIPLimits(t *testing.T) {
+	config := DefaultConfig()
+	config.TableIPLimit = 2
+	rt := NewRoutingTable(NewContact(NewKademliaID("0000000000000000000000000000000000000000"), "10.0.0.100:8000"), config)
+
+	var rejected int
+	rt.Subscribe(func(event RoutingEvent) {
+		if event.Type == ContactRejected {
+			rejected++
+		}
+	})
+	// These land in different buckets but share one IP.
+	for _, id := range []string{"8000000000000000000000000000000000000000", "4000000000000000000000000000000000000000", "2000000000000000000000000000000000000000"} {
+		rt.AddContact(NewContact(NewKademliaID(id), "10.0.0.1:8000"), &mockRPC{})
+	}
+
+	if contacts := rt.FindClosestContacts(NewRandomKademliaID(), 10); len(contacts) != 2 {
+		t.Errorf("Expected 2 contacts in the table, got %d", len(contacts))
+	}
+	if rejected != 1 {
+		t.Errorf("Expected 1 rejected contact, got %d", rejected)
+	}
+}
+
+func TestRoutingTablePenalize(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID("0000000000000000000000000000000000000000"), "localhost:8000"), DefaultConfig())
+	mockRPC := &mockRPC{}
+	liar := NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	rt.AddContact(liar, mockRPC)
+
+	for i := 1; i < -MinReputation; i++ {
+		rt.Penalize(liar.ID)
+		contacts := rt.FindClosestContacts(liar.ID, 1)
+		if len(contacts) != 1 || contacts[0].Reputation() != -i {
+			t.Fatalf("Expected the contact to stay with reputation %d, got %v", -i, contacts)
+		}
+		// Hearing from the contact again keeps its reputation.
+		rt.AddContact(liar, mockRPC)
+	}
+
+	rt.Penalize(liar.ID)
+	rt.AddContact(liar, mockRPC)
+	if contacts := rt.FindClosestContacts(liar.ID, 1); len(contacts) != 0 {
+		t.Errorf("Expected the contact to be evicted and refused, got %v", contacts)
+	}
+
+	// Once DistrustTTL has passed, the contact is accepted again with a clean reputation.
+	rt.distrusted[*liar.ID] = time.Now().Add(-time.Second)
+	rt.AddContact(liar, mockRPC)
+	if contacts := rt.FindClosestContacts(liar.ID, 1); len(contacts) != 1 || contacts[0].Reputation() != 0 {
+		t.Errorf("Expected the contact to be accepted again, got %v", contacts)
+	}
+}
+
+func TestReputationRecovers(t *testing.T) {
+	contact := NewContact(NewRandomKademliaID(), "localhost:8001")
+	contact.reputation = -2
+	contact.penalized = time.Now()
+	if reputation := contact.Reputation(); reputation != -2 {
+		t.Errorf("Expected reputation -2 right after the penalty, got %d", reputation)
+	}
+	contact.penalized = time.Now().Add(-ReputationRecovery - time.Second)
+	if reputation := contact.Reputation(); reputation != -1 {
+		t.Errorf("Expected reputation -1 after %v, got %d", ReputationRecovery, reputation)
+	}
+	contact.penalized = time.Now().Add(-10 * ReputationRecovery)
+	if reputation := contact.Reputation(); reputation != 0 {
+		t.Errorf("Expected the reputation to recover no further than 0, got %d", reputation)
+	}
+}
+
+func TestDistrustedIDsAreBounded(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewRandomKademliaID(), "localhost:8000"), DefaultConfig())
+	first := NewRandomKademliaID()
+	rt.distrust(first)
+	rt.distrusted[*first] = time.Now().Add(DistrustTTL - time.Minute)
+	for i := 0; i < maxDistrusted; i++ {
+		rt.distrust(NewRandomKademliaID())
+	}
+	if len(rt.distrusted) > maxDistrusted {
+		t.Errorf("Expected at most %d distrusted IDs, got %d", maxDistrusted, len(rt.distrusted))
+	}
+	if rt.distrusts(first) {
+		t.Error("Expected the ID closest to being trusted again to be forgotten first")
+	}
+}
@@ -0,0 +1,593 @@
+// pkg/dht/store.go
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ValueTTL is how long a stored value is kept unless it is stored again
+const ValueTTL = 24 * time.Hour
+
+// maxTombstones bounds the number of tombstones a Store keeps. Beyond it, the
+// tombstones that did not remove anything held here are dropped first, and
+// among those the ones that expire soonest.
+const maxTombstones = 10000
+
+// maxTombstonesPerSigner bounds the tombstones a Store keeps from one signer
+// that did not remove anything held here, enough to delete the largest object.
+const maxTombstonesPerSigner = MaxChunks + 1
+
+// storedValue is a value together with its expiry time, when it was last
+// stored or read and the publications of the nodes that published it, if
+// known. For a Record, data holds the encoded record, sequence its sequence
+// number and owner the public key it is signed with. cached is set for copies
+// cached along a lookup path, which are handed off as such.
+type storedValue struct {
+	data         []byte
+	expires      time.Time
+	accessed     time.Time
+	publications []Publication
+	record       bool
+	sequence     uint64
+	owner        ed25519.PublicKey
+	cached       bool
+}
+
+// deletion is a tombstone as kept by the Store. vouched is set if it removed
+// a publication or record held here.
+type deletion struct {
+	publisher ed25519.PublicKey
+	issued    time.Time
+	expires   time.Time
+	vouched   bool
+}
+
+// Store definition
+// keeps the key/value pairs this node is responsible for, within its quota,
+// and the tombstones of deleted values
+type Store struct {
+	mutex      sync.RWMutex
+	values     map[KademliaID]storedValue
+	tombstones map[KademliaID][]deletion
+	deletions  int
+	unvouched  map[string]int
+	bytes      int
+	quota      Quota
+	self       *KademliaID
+}
+
+// NewStore returns a new, empty Store without limits
+func NewStore() *Store {
+	return &Store{
+		values:     make(map[KademliaID]storedValue),
+		tombstones: make(map[KademliaID][]deletion),
+		unvouched:  make(map[string]int),
+		quota:      Quota{Policy: EvictNothing},
+	}
+}
+
+// SetQuota limits the Store to quota. The farthest eviction policy measures
+// distances from self, the ID of the node that owns the Store. Values already
+// stored are only evicted to make room for new ones.
+func (store *Store) SetQuota(quota Quota, self *KademliaID) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.quota = quota
+	store.self = self
+}
+
+// Quota returns the limits of the Store
+func (store *Store) Quota() Quota {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.quota
+}
+
+// Put stores data under key for the duration of ttl, replacing any previous
+// value. A Record stored under key is left alone, it can only be replaced
+// through PutRecord. It fails with ErrStoreFull if the value does not fit
+// into the quota and the eviction policy frees no room for it, and with
+// ErrDeleted once the tombstone of a publisher removed the value here.
+func (store *Store) Put(key *KademliaID, data []byte, ttl time.Duration) error {
+	return store.PutFrom(key, data, ttl, nil)
+}
+
+// PutFrom is like Put for a value attributed to its publishers by
+// publications, which are verified first. The publications are added to those
+// of the value already stored, and a later expiry is kept. While the key holds
+// tombstones, only publications issued after their signer's tombstone are
+// accepted, and storing fails with ErrDeleted if none is left.
+func (store *Store) PutFrom(key *KademliaID, data []byte, ttl time.Duration, publications []Publication) error {
+	return store.putValue(key, data, ttl, publications, false)
+}
+
+// Cache stores a copy of data found by a lookup for the duration of ttl, with
+// PutRecord if it is a record and Put otherwise. The copy is marked as cached
+// unless the value is already stored here for its own sake.
+func (store *Store) Cache(key *KademliaID, data []byte, ttl time.Duration) error {
+	if !NewKademliaIDFromData(data).Equals(key) {
+		if record, err := DecodeRecord(key, data); err == nil {
+			return store.putRecord(record, ttl, true)
+		}
+	}
+	return store.putValue(key, data, ttl, nil, true)
+}
+
+// putValue implements PutFrom and Cache.
+func (store *Store) putValue(key *KademliaID, data []byte, ttl time.Duration, publications []Publication, cached bool) error {
+	for i := range publications {
+		if err := publications[i].Verify(key); err != nil {
+			return err
+		}
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	publications, err := store.admit(key, publications)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(ttl)
+	old, ok := store.values[*key]
+	if ok && time.Now().Before(old.expires) {
+		if old.record {
+			return nil
+		}
+		publications = mergePublications(old.publications, publications)
+		if bytes.Equal(old.data, data) && old.expires.After(expires) {
+			expires = old.expires
+		}
+		cached = cached && old.cached
+	}
+	return store.set(key, storedValue{
+		data:         data,
+		expires:      expires,
+		publications: publications,
+		cached:       cached,
+	})
+}
+
+// Publications returns the publications of the value stored under key.
+func (store *Store) Publications(key *KademliaID) []Publication {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return append([]Publication(nil), store.values[*key].publications...)
+}
+
+// Delete verifies tombstone and revokes its signer's publication of the value
+// stored under its key. The value is removed once no publication is left, or
+// right away if it is a Record owned by the signer. It fails with
+// ErrNotPublisher if the signer did not publish the value. Copies nobody
+// vouches for, such as cached ones, are left to expire. The tombstone is kept
+// until it expires, even if no value is stored, so that copies with the
+// revoked publication arriving later are refused.
+func (store *Store) Delete(tombstone *Tombstone) error {
+	if err := tombstone.Verify(); err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := &tombstone.Key
+	vouched := false
+	if value, ok := store.values[*key]; ok && time.Now().Before(value.expires) {
+		switch {
+		case value.record:
+			if !bytes.Equal(value.owner, tombstone.PublicKey) {
+				return ErrNotPublisher
+			}
+			store.remove(key)
+			vouched = true
+		case len(value.publications) > 0:
+			i := slices.IndexFunc(value.publications, func(publication Publication) bool {
+				return bytes.Equal(publication.PublicKey, tombstone.PublicKey)
+			})
+			if i < 0 {
+				return ErrNotPublisher
+			}
+			// A publication issued after the tombstone stands.
+			if !value.publications[i].Issued.After(tombstone.Issued) {
+				value.publications = slices.Delete(slices.Clone(value.publications), i, i+1)
+				if len(value.publications) == 0 {
+					store.remove(key)
+				} else {
+					store.values[*key] = value
+				}
+				vouched = true
+			}
+		}
+	}
+	store.addDeletion(tombstone, vouched)
+	return nil
+}
+
+// admit drops the publications revoked by the tombstones of key, and fails
+// with ErrDeleted if none is left. A copy nobody vouches for is only refused
+// once the tombstone of a publisher removed the value here, since anyone can
+// sign a tombstone. The caller must hold the write lock.
+func (store *Store) admit(key *KademliaID, publications []Publication) ([]Publication, error) {
+	deletions := store.liveDeletions(key)
+	if len(deletions) == 0 {
+		return publications, nil
+	}
+	if len(publications) == 0 {
+		if slices.ContainsFunc(deletions, func(d deletion) bool { return d.vouched }) {
+			return nil, ErrDeleted
+		}
+		return nil, nil
+	}
+	var admitted []Publication
+	for _, publication := range publications {
+		revoked := slices.ContainsFunc(deletions, func(d deletion) bool {
+			return bytes.Equal(d.publisher, publication.PublicKey) && !publication.Issued.After(d.issued)
+		})
+		if !revoked {
+			admitted = append(admitted, publication)
+		}
+	}
+	if len(admitted) == 0 {
+		return nil, ErrDeleted
+	}
+	return admitted, nil
+}
+
+// liveDeletions returns the tombstones of key that have not expired, dropping
+// the expired ones. The caller must hold the write lock.
+func (store *Store) liveDeletions(key *KademliaID) []deletion {
+	deletions, ok := store.tombstones[*key]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	live := slices.DeleteFunc(deletions, func(d deletion) bool {
+		if now.After(d.expires) {
+			store.forgetDeletion(d)
+			return true
+		}
+		return false
+	})
+	if len(live) == 0 {
+		delete(store.tombstones, *key)
+		return nil
+	}
+	store.tombstones[*key] = live
+	return live
+}
+
+// addDeletion keeps tombstone, merging it with the one of the same signer.
+// vouched is set if it removed a publication or record held here. Since
+// anyone can sign a tombstone, the other ones are limited per signer and
+// never displace a vouched tombstone. The caller must hold the write lock.
+func (store *Store) addDeletion(tombstone *Tombstone, vouched bool) {
+	key := &tombstone.Key
+	added := deletion{publisher: tombstone.PublicKey, issued: tombstone.Issued, expires: tombstone.Expires(), vouched: vouched}
+	for i, d := range store.liveDeletions(key) {
+		if bytes.Equal(d.publisher, added.publisher) {
+			if !added.issued.After(d.issued) {
+				added.issued, added.expires = d.issued, d.expires
+			}
+			added.vouched = vouched || d.vouched
+			store.forgetDeletion(d)
+			store.countDeletion(added)
+			store.tombstones[*key][i] = added
+			return
+		}
+	}
+
+	// Room is made for a tenth of the limit at once, so that a flood of
+	// tombstones does not scan all of them for every one added.
+	if !vouched && store.unvouched[string(added.publisher)] >= maxTombstonesPerSigner {
+		store.dropDeletions(maxTombstonesPerSigner/10, func(d deletion) bool {
+			return !d.vouched && bytes.Equal(d.publisher, added.publisher)
+		})
+	}
+	if store.deletions >= maxTombstones {
+		now := time.Now()
+		store.dropDeletions(store.deletions, func(d deletion) bool { return now.After(d.expires) })
+	}
+	if store.deletions >= maxTombstones && store.dropDeletions(maxTombstones/10, func(d deletion) bool { return vouched || !d.vouched }) == 0 {
+		return
+	}
+	store.tombstones[*key] = append(store.tombstones[*key], added)
+	store.countDeletion(added)
+}
+
+// dropDeletions drops up to count of the tombstones for which eligible
+// returns true, those that did not remove anything held here first and then
+// those expiring soonest. It returns how many it dropped. The caller must
+// hold the write lock.
+func (store *Store) dropDeletions(count int, eligible func(d deletion) bool) int {
+	type candidate struct {
+		key      KademliaID
+		deletion deletion
+	}
+	var candidates []candidate
+	for key, deletions := range store.tombstones {
+		for _, d := range deletions {
+			if eligible(d) {
+				candidates = append(candidates, candidate{key, d})
+			}
+		}
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		if a.deletion.vouched != b.deletion.vouched {
+			if a.deletion.vouched {
+				return 1
+			}
+			return -1
+		}
+		return a.deletion.expires.Compare(b.deletion.expires)
+	})
+
+	candidates = candidates[:min(count, len(candidates))]
+	for _, c := range candidates {
+		deletions := store.tombstones[c.key]
+		i := slices.IndexFunc(deletions, func(d deletion) bool {
+			return bytes.Equal(d.publisher, c.deletion.publisher)
+		})
+		store.forgetDeletion(deletions[i])
+		if deletions = slices.Delete(deletions, i, i+1); len(deletions) == 0 {
+			delete(store.tombstones, c.key)
+		} else {
+			store.tombstones[c.key] = deletions
+		}
+	}
+	return len(candidates)
+}
+
+// countDeletion adds d to the tombstone counts. The caller must hold the write lock.
+func (store *Store) countDeletion(d deletion) {
+	store.deletions++
+	if !d.vouched {
+		store.unvouched[string(d.publisher)]++
+	}
+}
+
+// forgetDeletion removes d from the tombstone counts. The caller must hold the write lock.
+func (store *Store) forgetDeletion(d deletion) {
+	store.deletions--
+	if d.vouched {
+		return
+	}
+	signer := string(d.publisher)
+	if store.unvouched[signer]--; store.unvouched[signer] <= 0 {
+		delete(store.unvouched, signer)
+	}
+}
+
+// mergePublications adds publications to old, keeping the latest publication of each publisher.
+func mergePublications(old, publications []Publication) []Publication {
+	merged := slices.Clone(old)
+	for _, publication := range publications {
+		i := slices.IndexFunc(merged, func(existing Publication) bool {
+			return bytes.Equal(existing.PublicKey, publication.PublicKey)
+		})
+		switch {
+		case i < 0:
+			merged = append(merged, publication)
+		case publication.Issued.After(merged[i].Issued):
+			merged[i] = publication
+		}
+	}
+	return merged
+}
+
+// PutRecord verifies record and stores it for the duration of ttl. It fails
+// with ErrStaleRecord unless the record's sequence is higher than the stored
+// one's. Storing the same record again only extends its lifetime, it never
+// shortens it.
+func (store *Store) PutRecord(record *Record, ttl time.Duration) error {
+	return store.putRecord(record, ttl, false)
+}
+
+// putRecord implements PutRecord and Cache.
+func (store *Store) putRecord(record *Record, ttl time.Duration, cached bool) error {
+	if err := record.Verify(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	key := record.Key()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	revoked := slices.ContainsFunc(store.liveDeletions(key), func(d deletion) bool {
+		return bytes.Equal(d.publisher, record.PublicKey)
+	})
+	if revoked {
+		return ErrDeleted
+	}
+	expires := time.Now().Add(ttl)
+	if old, ok := store.values[*key]; ok && old.record && time.Now().Before(old.expires) {
+		if record.Sequence < old.sequence || (record.Sequence == old.sequence && !bytes.Equal(data, old.data)) {
+			return ErrStaleRecord
+		}
+		if record.Sequence == old.sequence && old.expires.After(expires) {
+			expires = old.expires
+		}
+		cached = cached && old.cached
+	}
+	return store.set(key, storedValue{
+		data:     data,
+		expires:  expires,
+		record:   true,
+		sequence: record.Sequence,
+		owner:    record.PublicKey,
+		cached:   cached,
+	})
+}
+
+// set stores value under key, keeping the byte count up to date and evicting
+// other values if the quota requires it. The caller must hold the write lock.
+func (store *Store) set(key *KademliaID, value storedValue) error {
+	if err := store.makeRoom(key, len(value.data)); err != nil {
+		storeRejections.Inc()
+		return err
+	}
+	if old, ok := store.values[*key]; ok {
+		store.bytes -= len(old.data)
+	}
+	value.accessed = time.Now()
+	store.bytes += len(value.data)
+	store.values[*key] = value
+	return nil
+}
+
+// makeRoom evicts values until size bytes fit under key, first the expired
+// ones and then as the eviction policy decides. The caller must hold the write lock.
+func (store *Store) makeRoom(key *KademliaID, size int) error {
+	if store.quota.MaxBytes > 0 && size > store.quota.MaxBytes {
+		return ErrStoreFull
+	}
+	full := func() bool {
+		bytes, keys := store.bytes+size, len(store.values)+1
+		if old, ok := store.values[*key]; ok {
+			bytes -= len(old.data)
+			keys--
+		}
+		return (store.quota.MaxBytes > 0 && bytes > store.quota.MaxBytes) ||
+			(store.quota.MaxKeys > 0 && keys > store.quota.MaxKeys)
+	}
+	if !full() {
+		return nil
+	}
+
+	now := time.Now()
+	for id, value := range store.values {
+		if now.After(value.expires) {
+			store.remove(&id)
+		}
+	}
+	for full() {
+		victim, ok := store.victim(key)
+		if !ok {
+			return ErrStoreFull
+		}
+		store.remove(victim)
+		storeEvictions.Inc()
+	}
+	return nil
+}
+
+// victim returns the key of the value the eviction policy removes to make room
+// for a value under key, or false if none should be. The caller must hold the lock.
+func (store *Store) victim(key *KademliaID) (*KademliaID, bool) {
+	var victim *KademliaID
+	var worst storedValue
+	for id, value := range store.values {
+		if id == *key {
+			continue
+		}
+		var worse bool
+		switch store.quota.Policy {
+		case EvictFarthest:
+			worse = victim == nil || victim.CalcDistance(store.self).Less(id.CalcDistance(store.self))
+		case EvictLeastRecentlyUsed:
+			worse = victim == nil || value.accessed.Before(worst.accessed)
+		case EvictSoonestExpiry:
+			worse = victim == nil || value.expires.Before(worst.expires)
+		}
+		if worse {
+			id := id
+			victim, worst = &id, value
+		}
+	}
+	if victim == nil {
+		return nil, false
+	}
+	// Keep the values the node is more responsible for than the new one.
+	if store.quota.Policy == EvictFarthest && !key.CalcDistance(store.self).Less(victim.CalcDistance(store.self)) {
+		return nil, false
+	}
+	return victim, true
+}
+
+// Get returns the value stored under key, if it exists and has not expired
+func (store *Store) Get(key *KademliaID) ([]byte, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	value, ok := store.values[*key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(value.expires) {
+		store.remove(key)
+		return nil, false
+	}
+	value.accessed = time.Now()
+	store.values[*key] = value
+	return value.data, true
+}
+
+// Expires returns when the value stored under key expires, if there is one.
+func (store *Store) Expires(key *KademliaID) (time.Time, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	value, ok := store.values[*key]
+	if !ok || time.Now().After(value.expires) {
+		return time.Time{}, false
+	}
+	return value.expires, true
+}
+
+// IsRecord reports whether a Record is stored under key.
+func (store *Store) IsRecord(key *KademliaID) bool {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	value, ok := store.values[*key]
+	return ok && value.record && time.Now().Before(value.expires)
+}
+
+// IsCached reports whether the value stored under key is a copy cached along
+// a lookup path.
+func (store *Store) IsCached(key *KademliaID) bool {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	value, ok := store.values[*key]
+	return ok && value.cached && time.Now().Before(value.expires)
+}
+
+// Keys returns the keys of all values that have not expired
+func (store *Store) Keys() []KademliaID {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	now := time.Now()
+	keys := make([]KademliaID, 0, len(store.values))
+	for key, value := range store.values {
+		if now.Before(value.expires) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Len returns the number of values in the Store, including expired ones not yet removed
+func (store *Store) Len() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return len(store.values)
+}
+
+// Bytes returns the total size of the values in the Store
+func (store *Store) Bytes() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.bytes
+}
+
+// remove deletes the value stored under key. The caller must hold the write lock.
+func (store *Store) remove(key *KademliaID) {
+	if value, ok := store.values[*key]; ok {
+		store.bytes -= len(value.data)
+		delete(store.values, *key)
+	}
+}
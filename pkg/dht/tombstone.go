@@ -0,0 +1,112 @@
+// pkg/dht/tombstone.go
+package dht
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// TombstoneTTL is how long a node keeps a tombstone, and how old a tombstone
+// may be when it arrives. It matches ValueTTL, so that copies the publisher
+// never reached have expired by the time the tombstone does.
+const TombstoneTTL = ValueTTL
+
+// maxClockSkew is how far in the future a tombstone or publication may claim to be issued.
+const maxClockSkew = time.Hour
+
+var (
+	// ErrDeleted is returned when storing a value whose key holds a tombstone.
+	ErrDeleted = errors.New("value has been deleted")
+	// ErrNotPublisher is returned for a tombstone not signed by a publisher of the value.
+	ErrNotPublisher = errors.New("tombstone is not signed by a publisher")
+)
+
+// Publication attributes the value stored under a key to the owner of
+// PublicKey, who may delete it again with a Tombstone. Since values are
+// stored under their content hash, several nodes may publish the same value,
+// and it is kept as long as any of them has not deleted it.
+type Publication struct {
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Issued    time.Time         `json:"issued"`
+	Signature []byte            `json:"signature"`
+}
+
+// NewPublication returns a publication of the value stored under key signed by identity.
+func NewPublication(identity *Identity, key *KademliaID) *Publication {
+	publication := &Publication{
+		PublicKey: identity.PublicKey,
+		Issued:    time.Now().UTC(),
+	}
+	publication.Signature = identity.Sign(publication.signedData(key))
+	return publication
+}
+
+// Verify checks that the publication was signed for key and is not issued in the future.
+func (publication *Publication) Verify(key *KademliaID) error {
+	if len(publication.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	if !ed25519.Verify(publication.PublicKey, publication.signedData(key), publication.Signature) {
+		return errors.New("invalid publication signature")
+	}
+	if time.Until(publication.Issued) > maxClockSkew {
+		return errors.New("publication is issued in the future")
+	}
+	return nil
+}
+
+// signedData returns the bytes covered by the signature.
+func (publication *Publication) signedData(key *KademliaID) []byte {
+	data := []byte("kademlia-publication")
+	data = append(data, key[:]...)
+	return binary.BigEndian.AppendUint64(data, uint64(publication.Issued.UnixNano()))
+}
+
+// Tombstone asks the nodes holding the value stored under Key to delete it.
+// It is signed by a publisher of the value, or by the owner of a Record, and
+// revokes the publications its signer issued before it.
+type Tombstone struct {
+	Key       KademliaID        `json:"key"`
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Issued    time.Time         `json:"issued"`
+	Signature []byte            `json:"signature"`
+}
+
+// NewTombstone returns a tombstone for key signed by identity.
+func NewTombstone(identity *Identity, key *KademliaID) *Tombstone {
+	tombstone := &Tombstone{
+		Key:       *key,
+		PublicKey: identity.PublicKey,
+		Issued:    time.Now().UTC(),
+	}
+	tombstone.Signature = identity.Sign(tombstone.signedData())
+	return tombstone
+}
+
+// Verify checks the tombstone's signature and that it has not expired.
+func (tombstone *Tombstone) Verify() error {
+	if len(tombstone.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	if !ed25519.Verify(tombstone.PublicKey, tombstone.signedData(), tombstone.Signature) {
+		return errors.New("invalid tombstone signature")
+	}
+	if age := time.Since(tombstone.Issued); age > TombstoneTTL || age < -maxClockSkew {
+		return errors.New("tombstone has expired or is issued in the future")
+	}
+	return nil
+}
+
+// Expires returns when nodes drop the tombstone.
+func (tombstone *Tombstone) Expires() time.Time {
+	return tombstone.Issued.Add(TombstoneTTL)
+}
+
+// signedData returns the bytes covered by the signature.
+func (tombstone *Tombstone) signedData() []byte {
+	data := []byte("kademlia-tombstone")
+	data = append(data, tombstone.Key[:]...)
+	return binary.BigEndian.AppendUint64(data, uint64(tombstone.Issued.UnixNano()))
+}
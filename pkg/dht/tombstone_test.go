@@ -0,0 +1,233 @@
+package dht
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestIdentities returns n identities without crypto puzzles.
+func newTestIdentities(t *testing.T, n int) []*Identity {
+	t.Helper()
+	identities := make([]*Identity, n)
+	for i := range identities {
+		identity, err := NewIdentity(DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities[i] = identity
+	}
+	return identities
+}
+
+func TestStoreDeleteRevokesPublications(t *testing.T) {
+	identities := newTestIdentities(t, 2)
+	publisher, stranger := identities[0], identities[1]
+	store := NewStore()
+	data := []byte("to be deleted")
+	key := NewKademliaIDFromData(data)
+
+	publication := NewPublication(publisher, key)
+	if err := store.PutFrom(key, data, ValueTTL, []Publication{*publication}); err != nil {
+		t.Fatal(err)
+	}
+	// Storing the value again without publications keeps the publisher.
+	store.Put(key, data, ValueTTL)
+	if publications := store.Publications(key); len(publications) != 1 {
+		t.Fatalf("Expected the publication to be kept, got %d", len(publications))
+	}
+	claimed := *NewPublication(stranger, key)
+	claimed.PublicKey = publisher.PublicKey
+	if err := store.PutFrom(key, data, ValueTTL, []Publication{claimed}); err == nil {
+		t.Error("Expected a publication not signed by its publisher to be rejected")
+	}
+
+	if err := store.Delete(NewTombstone(stranger, key)); !errors.Is(err, ErrNotPublisher) {
+		t.Errorf("Expected a tombstone from someone else to be rejected, got %v", err)
+	}
+	forged := NewTombstone(stranger, key)
+	forged.PublicKey = publisher.PublicKey
+	if err := store.Delete(forged); err == nil {
+		t.Error("Expected a tombstone with a forged signature to be rejected")
+	}
+
+	tombstone := NewTombstone(publisher, key)
+	if err := store.Delete(tombstone); err != nil {
+		t.Fatalf("Expected the publisher's tombstone to be accepted, got %v", err)
+	}
+	if _, ok := store.Get(key); ok {
+		t.Error("Expected the value to be gone")
+	}
+	if err := store.Put(key, data, ValueTTL); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Expected a copy nobody vouches for not to be stored again, got %v", err)
+	}
+	if err := store.PutFrom(key, data, ValueTTL, []Publication{*publication}); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Expected a copy with the revoked publication not to be stored again, got %v", err)
+	}
+
+	// Publishing the value again after deleting it is fine.
+	republished := &Publication{PublicKey: publisher.PublicKey, Issued: tombstone.Issued.Add(time.Second)}
+	republished.Signature = publisher.Sign(republished.signedData(key))
+	if err := store.PutFrom(key, data, ValueTTL, []Publication{*republished}); err != nil {
+		t.Errorf("Expected a newer publication to be accepted, got %v", err)
+	}
+}
+
+func TestSharedValuesOutliveOnePublisher(t *testing.T) {
+	identities := newTestIdentities(t, 2)
+	publisher, other := identities[0], identities[1]
+	store := NewStore()
+	data := []byte("published twice")
+	key := NewKademliaIDFromData(data)
+
+	store.PutFrom(key, data, ValueTTL, []Publication{*NewPublication(publisher, key)})
+	store.PutFrom(key, data, ValueTTL, []Publication{*NewPublication(other, key)})
+	if err := store.Delete(NewTombstone(other, key)); err != nil {
+		t.Fatalf("Expected the other publisher's tombstone to be accepted, got %v", err)
+	}
+	if _, ok := store.Get(key); !ok {
+		t.Fatal("Expected the value to stay while it has a publisher")
+	}
+	if publications := store.Publications(key); len(publications) != 1 || !publications[0].PublicKey.Equal(publisher.PublicKey) {
+		t.Errorf("Expected only the first publisher to be left, got %v", publications)
+	}
+
+	if err := store.Delete(NewTombstone(publisher, key)); err != nil {
+		t.Fatalf("Expected the publisher's tombstone to be accepted, got %v", err)
+	}
+	if _, ok := store.Get(key); ok {
+		t.Error("Expected the value to be gone once nobody publishes it")
+	}
+}
+
+func TestTombstonesAreKeptWithoutValue(t *testing.T) {
+	publisher := newTestIdentities(t, 1)[0]
+	store := NewStore()
+	data := []byte("not here yet")
+	key := NewKademliaIDFromData(data)
+	stale := NewPublication(publisher, key)
+
+	if err := store.Delete(NewTombstone(publisher, key)); err != nil {
+		t.Fatalf("Expected a tombstone for a missing value to be kept, got %v", err)
+	}
+	if err := store.PutFrom(key, data, ValueTTL, []Publication{*stale}); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Expected a handed off copy arriving later to be refused, got %v", err)
+	}
+	// The tombstone did not remove anything here, so it cannot speak for
+	// copies nobody vouches for.
+	if err := store.Put(key, data, ValueTTL); err != nil {
+		t.Errorf("Expected a cached copy to be stored, got %v", err)
+	}
+	if err := store.Delete(NewTombstone(publisher, key)); err != nil {
+		t.Errorf("Expected the tombstone to be accepted, got %v", err)
+	}
+	if _, ok := store.Get(key); !ok {
+		t.Error("Expected the cached copy to be left to expire")
+	}
+
+	// Once the tombstone expires the value can be stored again.
+	store.tombstones[*key][0].expires = time.Now().Add(-time.Second)
+	if err := store.PutFrom(key, data, ValueTTL, []Publication{*stale}); err != nil {
+		t.Errorf("Expected the value to be stored after the tombstone expired, got %v", err)
+	}
+}
+
+// floodTombstone returns an unsigned tombstone for a random key by a random
+// signer, issued as late as Verify accepts so that it outlives honest ones.
+func floodTombstone() *Tombstone {
+	signer := make([]byte, 32)
+	copy(signer, NewRandomKademliaID()[:])
+	return &Tombstone{Key: *NewRandomKademliaID(), PublicKey: signer, Issued: time.Now().Add(maxClockSkew)}
+}
+
+func TestTombstonesAreBounded(t *testing.T) {
+	store := NewStore()
+	first := floodTombstone()
+	first.Issued = time.Now().Add(-time.Hour)
+	store.addDeletion(first, false)
+	for i := 0; i < maxTombstones; i++ {
+		store.addDeletion(floodTombstone(), false)
+	}
+	if store.deletions > maxTombstones || len(store.tombstones) != store.deletions {
+		t.Errorf("Expected at most %d tombstones, got %d in %d keys", maxTombstones, store.deletions, len(store.tombstones))
+	}
+	if _, ok := store.tombstones[first.Key]; ok {
+		t.Error("Expected the tombstone expiring soonest to be dropped first")
+	}
+}
+
+func TestTombstoneFloodKeepsPublishersTombstones(t *testing.T) {
+	publisher := newTestIdentities(t, 1)[0]
+	store := NewStore()
+	data := []byte("deleted by its publisher")
+	key := NewKademliaIDFromData(data)
+	publication := NewPublication(publisher, key)
+	store.PutFrom(key, data, ValueTTL, []Publication{*publication})
+	if err := store.Delete(NewTombstone(publisher, key)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tombstones from fresh keys for values that are not held here expire
+	// later than the publisher's, but must not displace it.
+	for i := 0; i < 2*maxTombstones; i++ {
+		store.addDeletion(floodTombstone(), false)
+	}
+	if store.deletions > maxTombstones {
+		t.Errorf("Expected at most %d tombstones, got %d", maxTombstones, store.deletions)
+	}
+	if err := store.PutFrom(key, data, ValueTTL, []Publication{*publication}); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Expected the deleted value to stay deleted after the flood, got %v", err)
+	}
+	if err := store.Put(key, data, ValueTTL); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Expected copies nobody vouches for to stay refused after the flood, got %v", err)
+	}
+}
+
+func TestTombstonesAreLimitedPerSigner(t *testing.T) {
+	signer := newTestIdentities(t, 1)[0]
+	store := NewStore()
+	for i := 0; i <= maxTombstonesPerSigner; i++ {
+		if err := store.Delete(NewTombstone(signer, NewRandomKademliaID())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.deletions > maxTombstonesPerSigner {
+		t.Errorf("Expected at most %d tombstones from one signer, got %d", maxTombstonesPerSigner, store.deletions)
+	}
+}
+
+func TestTombstoneExpires(t *testing.T) {
+	identity := newTestIdentities(t, 1)[0]
+	tombstone := NewTombstone(identity, NewRandomKademliaID())
+	if err := tombstone.Verify(); err != nil {
+		t.Fatalf("Expected a new tombstone to verify, got %v", err)
+	}
+
+	// Re-sign with an old issue time, so that only the age is wrong.
+	tombstone.Issued = time.Now().Add(-TombstoneTTL - time.Minute)
+	tombstone.Signature = identity.Sign(tombstone.signedData())
+	if err := tombstone.Verify(); err == nil {
+		t.Error("Expected an expired tombstone to be rejected")
+	}
+}
+
+func TestRecordsAreDeletedByTheirOwner(t *testing.T) {
+	identities := newTestIdentities(t, 2)
+	owner, stranger := identities[0], identities[1]
+	store := NewStore()
+	record := NewRecord(owner, "profile", 1, []byte("value"))
+	if err := store.PutRecord(record, ValueTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Delete(NewTombstone(stranger, record.Key())); !errors.Is(err, ErrNotPublisher) {
+		t.Errorf("Expected a tombstone from someone else to be rejected, got %v", err)
+	}
+	if err := store.Delete(NewTombstone(owner, record.Key())); err != nil {
+		t.Fatalf("Expected the owner's tombstone to be accepted, got %v", err)
+	}
+	newer := NewRecord(owner, "profile", 2, []byte("newer"))
+	if err := store.PutRecord(newer, ValueTTL); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Expected the deleted record not to be stored again, got %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+// pkg/dht/trace.go
+package dht
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LookupEventType identifies what happened during a lookup
+type LookupEventType int
+
+const (
+	// LookupStarted is emitted once with the initial contacts taken from the routing table
+	LookupStarted LookupEventType = iota
+	// LookupRoundStarted is emitted with the contacts about to be queried in a round
+	LookupRoundStarted
+	// LookupResponse is emitted when Contact answered, with the contacts it returned
+	LookupResponse
+	// LookupFailed is emitted when Contact timed out or returned an error
+	LookupFailed
+	// LookupDiscovered is emitted with contacts first learned about from Contact's response
+	LookupDiscovered
+	// LookupFinished is emitted once with the result and the termination reason
+	LookupFinished
+)
+
+// Reasons a lookup terminates
+const (
+	ReasonConverged  = "k closest contacts responded"
+	ReasonExhausted  = "no contacts left"
+	ReasonValueFound = "value found"
+)
+
+// String returns a string representation of the LookupEventType
+func (t LookupEventType) String() string {
+	switch t {
+	case LookupStarted:
+		return "started"
+	case LookupRoundStarted:
+		return "round"
+	case LookupResponse:
+		return "response"
+	case LookupFailed:
+		return "failed"
+	case LookupDiscovered:
+		return "discovered"
+	case LookupFinished:
+		return "finished"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// LookupEvent describes a single step of a lookup. Path is the index of the
+// path of a DisjointLookup the event belongs to, and 0 for a plain Lookup.
+type LookupEvent struct {
+	Type     LookupEventType
+	Path     int
+	Round    int
+	Time     time.Time
+	Contact  *Contact
+	Contacts []Contact
+	Value    bool
+	Err      error
+	Reason   string
+}
+
+// LookupObserver receives the events of a lookup
+type LookupObserver func(event LookupEvent)
+
+// TraceContact is a contact as it appears in a LookupTrace
+type TraceContact struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Distance string `json:"distance"`
+}
+
+// TraceResponse is a successful query in a LookupTrace
+type TraceResponse struct {
+	Contact    TraceContact   `json:"contact"`
+	ElapsedMS  float64        `json:"elapsed_ms"`
+	Returned   int            `json:"returned"`
+	Discovered []TraceContact `json:"discovered,omitempty"`
+	Value      bool           `json:"value,omitempty"`
+}
+
+// TraceFailure is a failed query in a LookupTrace
+type TraceFailure struct {
+	Contact   TraceContact `json:"contact"`
+	ElapsedMS float64      `json:"elapsed_ms"`
+	Error     string       `json:"error"`
+}
+
+// TraceRound is one round of queries in a LookupTrace
+type TraceRound struct {
+	Path      int             `json:"path,omitempty"`
+	Round     int             `json:"round"`
+	Queried   []TraceContact  `json:"queried"`
+	Responses []TraceResponse `json:"responses"`
+	Failures  []TraceFailure  `json:"failures,omitempty"`
+}
+
+// LookupTrace is the full record of a lookup
+type LookupTrace struct {
+	Target     string         `json:"target"`
+	Initial    []TraceContact `json:"initial"`
+	Rounds     []TraceRound   `json:"rounds"`
+	Result     []TraceContact `json:"result"`
+	Reason     string         `json:"reason"`
+	DurationMS float64        `json:"duration_ms"`
+}
+
+// TraceRecorder is a LookupObserver that records a lookup so it can be
+// inspected afterwards, for example dumped as JSON or Graphviz DOT.
+type TraceRecorder struct {
+	mutex      sync.Mutex
+	target     *KademliaID
+	trace      LookupTrace
+	started    time.Time
+	roundStart map[int]time.Time
+	failed     map[string]bool
+}
+
+// NewTraceRecorder returns a TraceRecorder for a lookup of target
+func NewTraceRecorder(target *KademliaID) *TraceRecorder {
+	return &TraceRecorder{
+		target:     target,
+		trace:      LookupTrace{Target: target.String()},
+		roundStart: make(map[int]time.Time),
+		failed:     make(map[string]bool),
+	}
+}
+
+// Observe records an event, pass it to the SetObserver of a Lookup or DisjointLookup
+func (recorder *TraceRecorder) Observe(event LookupEvent) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	switch event.Type {
+	case LookupStarted:
+		recorder.started = event.Time
+		recorder.trace.Initial = recorder.traceContacts(event.Contacts)
+	case LookupRoundStarted:
+		recorder.roundStart[event.Path] = event.Time
+		recorder.trace.Rounds = append(recorder.trace.Rounds, TraceRound{
+			Path:    event.Path,
+			Round:   event.Round,
+			Queried: recorder.traceContacts(event.Contacts),
+		})
+	case LookupResponse:
+		round := recorder.currentRound(event.Path)
+		round.Responses = append(round.Responses, TraceResponse{
+			Contact:   recorder.traceContact(*event.Contact),
+			ElapsedMS: milliseconds(event.Time.Sub(recorder.roundStart[event.Path])),
+			Returned:  len(event.Contacts),
+			Value:     event.Value,
+		})
+	case LookupFailed:
+		round := recorder.currentRound(event.Path)
+		recorder.failed[event.Contact.ID.String()] = true
+		round.Failures = append(round.Failures, TraceFailure{
+			Contact:   recorder.traceContact(*event.Contact),
+			ElapsedMS: milliseconds(event.Time.Sub(recorder.roundStart[event.Path])),
+			Error:     event.Err.Error(),
+		})
+	case LookupDiscovered:
+		round := recorder.currentRound(event.Path)
+		for i := range round.Responses {
+			if round.Responses[i].Contact.ID == event.Contact.ID.String() {
+				round.Responses[i].Discovered = recorder.traceContacts(event.Contacts)
+			}
+		}
+	case LookupFinished:
+		recorder.trace.Result = recorder.traceContacts(event.Contacts)
+		recorder.trace.Reason = event.Reason
+		recorder.trace.DurationMS = milliseconds(event.Time.Sub(recorder.started))
+	}
+}
+
+// Trace returns what has been recorded so far
+func (recorder *TraceRecorder) Trace() LookupTrace {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.trace
+}
+
+// JSON returns the recorded trace as indented JSON
+func (recorder *TraceRecorder) JSON() ([]byte, error) {
+	return json.MarshalIndent(recorder.Trace(), "", "  ")
+}
+
+// DOT returns the recorded trace as a Graphviz digraph. Edges point from the
+// contact that answered to the contacts first learned about from its answer,
+// and are labelled with the round, prefixed by the path for a disjoint lookup.
+func (recorder *TraceRecorder) DOT() string {
+	trace := recorder.Trace()
+	recorder.mutex.Lock()
+	failed := recorder.failed
+	recorder.mutex.Unlock()
+
+	inResult := make(map[string]bool)
+	for _, contact := range trace.Result {
+		inResult[contact.ID] = true
+	}
+	// The paths of a disjoint lookup run side by side, so the lookup is as
+	// deep as the most rounds any path ran, not the number of rounds recorded.
+	paths := make(map[int]bool)
+	var depth int
+	for _, round := range trace.Rounds {
+		paths[round.Path] = true
+		depth = max(depth, round.Round)
+	}
+	queriedIn := make(map[string][]string)
+	for _, round := range trace.Rounds {
+		where := fmt.Sprintf("round %d", round.Round)
+		if len(paths) > 1 {
+			where = fmt.Sprintf("path %d round %d", round.Path, round.Round)
+		}
+		for _, contact := range round.Queried {
+			queriedIn[contact.ID] = append(queriedIn[contact.ID], where)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph lookup {\n")
+	fmt.Fprintf(&b, "  rankdir=LR;\n")
+	if len(paths) > 1 {
+		fmt.Fprintf(&b, "  label=\"lookup %s: %d rounds on %d paths, %s\";\n", shortID(trace.Target), depth, len(paths), trace.Reason)
+	} else {
+		fmt.Fprintf(&b, "  label=\"lookup %s: %d rounds, %s\";\n", shortID(trace.Target), depth, trace.Reason)
+	}
+	fmt.Fprintf(&b, "  origin [shape=doublecircle, label=\"origin\"];\n")
+
+	declared := make(map[string]bool)
+	declare := func(contact TraceContact) {
+		if declared[contact.ID] {
+			return
+		}
+		declared[contact.ID] = true
+		label := shortID(contact.ID)
+		for _, where := range queriedIn[contact.ID] {
+			label += "\\n" + where
+		}
+		attributes := fmt.Sprintf("label=\"%s\"", label)
+		var styles []string
+		if _, ok := queriedIn[contact.ID]; !ok {
+			// Never queried
+			styles = append(styles, "dashed")
+		}
+		switch {
+		case failed[contact.ID]:
+			attributes += ", color=red, fontcolor=red"
+		case inResult[contact.ID]:
+			styles = append(styles, "filled")
+			attributes += ", fillcolor=palegreen"
+		}
+		if len(styles) > 0 {
+			attributes += fmt.Sprintf(", style=\"%s\"", strings.Join(styles, ","))
+		}
+		fmt.Fprintf(&b, "  \"%s\" [%s];\n", contact.ID, attributes)
+	}
+
+	for _, contact := range trace.Initial {
+		declare(contact)
+		fmt.Fprintf(&b, "  origin -> \"%s\";\n", contact.ID)
+	}
+	for _, round := range trace.Rounds {
+		for _, response := range round.Responses {
+			declare(response.Contact)
+			for _, contact := range response.Discovered {
+				declare(contact)
+				fmt.Fprintf(&b, "  \"%s\" -> \"%s\" [label=\"%s\"];\n", response.Contact.ID, contact.ID, edgeLabel(round, len(paths) > 1))
+			}
+		}
+		for _, failure := range round.Failures {
+			declare(failure.Contact)
+		}
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+// edgeLabel labels an edge with its round, and its path if there are several.
+func edgeLabel(round TraceRound, disjoint bool) string {
+	if disjoint {
+		return fmt.Sprintf("%d.%d", round.Path, round.Round)
+	}
+	return fmt.Sprintf("%d", round.Round)
+}
+
+// currentRound returns the round of path being recorded. The caller must hold the mutex.
+func (recorder *TraceRecorder) currentRound(path int) *TraceRound {
+	for i := len(recorder.trace.Rounds) - 1; i >= 0; i-- {
+		if recorder.trace.Rounds[i].Path == path {
+			return &recorder.trace.Rounds[i]
+		}
+	}
+	recorder.trace.Rounds = append(recorder.trace.Rounds, TraceRound{Path: path})
+	return &recorder.trace.Rounds[len(recorder.trace.Rounds)-1]
+}
+
+// traceContact converts a contact, computing its distance to the target
+func (recorder *TraceRecorder) traceContact(contact Contact) TraceContact {
+	return TraceContact{
+		ID:       contact.ID.String(),
+		Address:  contact.Address,
+		Distance: contact.ID.CalcDistance(recorder.target).String(),
+	}
+}
+
+// traceContacts converts a list of contacts
+func (recorder *TraceRecorder) traceContacts(contacts []Contact) []TraceContact {
+	traced := make([]TraceContact, 0, len(contacts))
+	for _, contact := range contacts {
+		traced = append(traced, recorder.traceContact(contact))
+	}
+	return traced
+}
+
+// shortID abbreviates a hex encoded ID for display
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,174 @@
+// pkg/metrics/metrics.go
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	bits uint64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&c.bits, old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	Counter
+}
+
+// Set sets the gauge to value.
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	mutex   sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// newHistogram returns a Histogram with the given upper bucket bounds.
+func newHistogram(bounds []float64) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	return &Histogram{
+		bounds:  sorted,
+		buckets: make([]uint64, len(sorted)),
+	}
+}
+
+// Observe records a single observation.
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Count returns the number of observations recorded.
+func (h *Histogram) Count() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count
+}
+
+// snapshot returns a consistent copy of the histogram state.
+func (h *Histogram) snapshot() (buckets []uint64, count uint64, sum float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]uint64(nil), h.buckets...), h.count, h.sum
+}
+
+// vec keeps one child metric per combination of label values.
+type vec[T any] struct {
+	labelNames []string
+	mutex      sync.RWMutex
+	children   map[string]*T
+	newChild   func() *T
+}
+
+// with returns the child for the given label values, creating it if needed.
+func (v *vec[T]) with(values ...string) *T {
+	if len(values) != len(v.labelNames) {
+		panic("metrics: wrong number of label values")
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mutex.RLock()
+	child, ok := v.children[key]
+	v.mutex.RUnlock()
+	if ok {
+		return child
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child
+	}
+	child = v.newChild()
+	v.children[key] = child
+	return child
+}
+
+// each calls fn for every child, ordered by label values.
+func (v *vec[T]) each(fn func(values []string, child *T)) {
+	v.mutex.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	children := make(map[string]*T, len(v.children))
+	for key, child := range v.children {
+		children[key] = child
+	}
+	v.mutex.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		fn(strings.Split(key, "\xff"), children[key])
+	}
+}
+
+// CounterVec is a family of counters partitioned by labels.
+type CounterVec struct {
+	vec[Counter]
+}
+
+// WithLabelValues returns the counter for the given label values.
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	return cv.with(values...)
+}
+
+// HistogramVec is a family of histograms partitioned by labels.
+type HistogramVec struct {
+	vec[Histogram]
+}
+
+// WithLabelValues returns the histogram for the given label values.
+func (hv *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return hv.with(values...)
+}
+
+// LabeledValue is one sample of a GaugeVecFunc.
+type LabeledValue struct {
+	Labels []string
+	Value  float64
+}
@@ -0,0 +1,229 @@
+// pkg/metrics/registry.go
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram bounds suited to RPC latencies in seconds.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry used by the package level constructors.
+var Default = NewRegistry()
+
+// collector writes one metric family in the Prometheus text format.
+type collector struct {
+	name  string
+	help  string
+	kind  string
+	write func(w *bufio.Writer, name string)
+}
+
+// Registry holds a set of metrics and renders them in the Prometheus text
+// exposition format. It is written by hand to avoid pulling in the client library.
+type Registry struct {
+	mutex      sync.RWMutex
+	collectors []collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a collector, panicking on duplicate names as that is a programming error.
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, existing := range r.collectors {
+		if existing.name == c.name {
+			panic("metrics: duplicate metric " + c.name)
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// NewCounter registers and returns a counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	counter := &Counter{}
+	r.register(collector{name, help, "counter", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, counter.Value())
+	}})
+	return counter
+}
+
+// NewCounterVec registers and returns a counter family with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	cv := &CounterVec{vec[Counter]{
+		labelNames: labelNames,
+		children:   make(map[string]*Counter),
+		newChild:   func() *Counter { return &Counter{} },
+	}}
+	r.register(collector{name, help, "counter", func(w *bufio.Writer, name string) {
+		cv.each(func(values []string, counter *Counter) {
+			writeSample(w, name, labelNames, values, counter.Value())
+		})
+	}})
+	return cv
+}
+
+// NewGauge registers and returns a gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	gauge := &Gauge{}
+	r.register(collector{name, help, "gauge", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, gauge.Value())
+	}})
+	return gauge
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn at scrape time.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(collector{name, help, "gauge", func(w *bufio.Writer, name string) {
+		writeSample(w, name, nil, nil, fn())
+	}})
+}
+
+// NewGaugeVecFunc registers a labeled gauge whose samples are computed by fn at scrape time.
+func (r *Registry) NewGaugeVecFunc(name, help string, labelNames []string, fn func() []LabeledValue) {
+	r.register(collector{name, help, "gauge", func(w *bufio.Writer, name string) {
+		for _, sample := range fn() {
+			writeSample(w, name, labelNames, sample.Labels, sample.Value)
+		}
+	}})
+}
+
+// NewHistogram registers and returns a histogram with the given bucket bounds.
+func (r *Registry) NewHistogram(name, help string, bounds []float64) *Histogram {
+	histogram := newHistogram(bounds)
+	r.register(collector{name, help, "histogram", func(w *bufio.Writer, name string) {
+		writeHistogram(w, name, nil, nil, histogram)
+	}})
+	return histogram
+}
+
+// NewHistogramVec registers and returns a histogram family with the given label names.
+func (r *Registry) NewHistogramVec(name, help string, bounds []float64, labelNames ...string) *HistogramVec {
+	hv := &HistogramVec{vec[Histogram]{
+		labelNames: labelNames,
+		children:   make(map[string]*Histogram),
+		newChild:   func() *Histogram { return newHistogram(bounds) },
+	}}
+	r.register(collector{name, help, "histogram", func(w *bufio.Writer, name string) {
+		hv.each(func(values []string, histogram *Histogram) {
+			writeHistogram(w, name, labelNames, values, histogram)
+		})
+	}})
+	return hv
+}
+
+// WriteTo writes every registered metric to w in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.RLock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mutex.RUnlock()
+
+	counter := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		fmt.Fprintf(buffered, "# HELP %s %s\n", c.name, escapeHelp(c.help))
+		fmt.Fprintf(buffered, "# TYPE %s %s\n", c.name, c.kind)
+		c.write(buffered, c.name)
+	}
+	err := buffered.Flush()
+	return counter.n, err
+}
+
+// NewCounter registers a counter in the Default registry.
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// NewCounterVec registers a counter family in the Default registry.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+// NewGauge registers a gauge in the Default registry.
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// NewHistogram registers a histogram in the Default registry.
+func NewHistogram(name, help string, bounds []float64) *Histogram {
+	return Default.NewHistogram(name, help, bounds)
+}
+
+// NewHistogramVec registers a histogram family in the Default registry.
+func NewHistogramVec(name, help string, bounds []float64, labelNames ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, bounds, labelNames...)
+}
+
+// writeHistogram writes the _bucket, _sum and _count samples of a histogram.
+func writeHistogram(w *bufio.Writer, name string, labelNames, values []string, h *Histogram) {
+	buckets, count, sum := h.snapshot()
+	bucketLabels := append(append([]string(nil), labelNames...), "le")
+	for i, bound := range h.bounds {
+		bucketValues := append(append([]string(nil), values...), formatFloat(bound))
+		writeSample(w, name+"_bucket", bucketLabels, bucketValues, float64(buckets[i]))
+	}
+	writeSample(w, name+"_bucket", bucketLabels, append(append([]string(nil), values...), "+Inf"), float64(count))
+	writeSample(w, name+"_sum", labelNames, values, sum)
+	writeSample(w, name+"_count", labelNames, values, float64(count))
+}
+
+// writeSample writes a single sample line.
+func writeSample(w *bufio.Writer, name string, labelNames, values []string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 {
+		w.WriteByte('{')
+		for i, label := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// formatFloat formats a sample value the way Prometheus expects.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryTextFormat(t *testing.T) {
+	r := NewRegistry()
+	sent := r.NewCounterVec("rpc_sent_total", "RPCs sent.", "type")
+	sent.WithLabelValues("PING").Inc()
+	sent.WithLabelValues("PING").Inc()
+	sent.WithLabelValues("FIND_NODE").Add(3)
+
+	latency := r.NewHistogram("rpc_duration_seconds", "RPC latency.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(2)
+
+	r.NewGaugeVecFunc("bucket_contacts", "Contacts per bucket.", []string{"bucket"}, func() []LabeledValue {
+		return []LabeledValue{{Labels: []string{"7"}, Value: 4}}
+	})
+
+	var out strings.Builder
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	expected := `# HELP rpc_sent_total RPCs sent.
+# TYPE rpc_sent_total counter
+rpc_sent_total{type="FIND_NODE"} 3
+rpc_sent_total{type="PING"} 2
+# HELP rpc_duration_seconds RPC latency.
+# TYPE rpc_duration_seconds histogram
+rpc_duration_seconds_bucket{le="0.1"} 1
+rpc_duration_seconds_bucket{le="1"} 2
+rpc_duration_seconds_bucket{le="+Inf"} 3
+rpc_duration_seconds_sum 2.55
+rpc_duration_seconds_count 3
+# HELP bucket_contacts Contacts per bucket.
+# TYPE bucket_contacts gauge
+bucket_contacts{bucket="7"} 4
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("errors_total", "Errors.", "message").WithLabelValues("say \"hi\"\n").Inc()
+
+	var out strings.Builder
+	r.WriteTo(&out)
+	if !strings.Contains(out.String(), `errors_total{message="say \"hi\"\n"} 1`) {
+		t.Errorf("Label value not escaped:\n%s", out.String())
+	}
+}
@@ -0,0 +1,121 @@
+// pkg/network/memory.go
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// MemoryNetwork connects MemoryTransports within a single process.
+// It is mainly useful for tests and simulations.
+type MemoryNetwork struct {
+	mutex      sync.RWMutex
+	transports map[string]*MemoryTransport
+}
+
+// NewMemoryNetwork returns an empty in-memory network.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		transports: make(map[string]*MemoryTransport),
+	}
+}
+
+// Listen attaches a new transport to the network under addr.
+func (mn *MemoryNetwork) Listen(addr string) (*MemoryTransport, error) {
+	mn.mutex.Lock()
+	defer mn.mutex.Unlock()
+
+	if _, exists := mn.transports[addr]; exists {
+		return nil, fmt.Errorf("address %s already in use", addr)
+	}
+
+	t := &MemoryTransport{
+		network: mn,
+		addr:    addr,
+		packets: make(chan Packet, 64),
+	}
+	mn.transports[addr] = t
+	return t, nil
+}
+
+// lookup returns the transport listening on addr, if any.
+func (mn *MemoryNetwork) lookup(addr string) *MemoryTransport {
+	mn.mutex.RLock()
+	defer mn.mutex.RUnlock()
+	return mn.transports[addr]
+}
+
+// remove detaches the transport listening on addr.
+func (mn *MemoryNetwork) remove(addr string) {
+	mn.mutex.Lock()
+	defer mn.mutex.Unlock()
+	delete(mn.transports, addr)
+}
+
+// MemoryTransport is a Transport that delivers packets through a MemoryNetwork.
+type MemoryTransport struct {
+	network *MemoryNetwork
+	addr    string
+	mutex   sync.RWMutex
+	closed  bool
+	packets chan Packet
+}
+
+// Send delivers a copy of data to the transport listening on addr.
+// Like UDP, packets to unknown or closed addresses are silently dropped.
+func (t *MemoryTransport) Send(addr string, data []byte) error {
+	t.mutex.RLock()
+	closed := t.closed
+	t.mutex.RUnlock()
+	if closed {
+		return errors.New("transport closed")
+	}
+
+	remote := t.network.lookup(addr)
+	if remote == nil {
+		return nil
+	}
+
+	packet := Packet{Data: append([]byte(nil), data...), From: t.addr}
+	remote.deliver(packet)
+	return nil
+}
+
+// deliver queues a packet on the transport unless it is closed. Like UDP,
+// the packet is dropped when the receive buffer is full, so a receiver that
+// does not keep up never blocks its senders.
+func (t *MemoryTransport) deliver(packet Packet) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.packets <- packet:
+	default:
+	}
+}
+
+// Receive returns the channel of incoming packets.
+func (t *MemoryTransport) Receive() <-chan Packet {
+	return t.packets
+}
+
+// LocalAddr returns the address the transport was registered under.
+func (t *MemoryTransport) LocalAddr() string {
+	return t.addr
+}
+
+// Close detaches the transport from its network.
+func (t *MemoryTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	t.network.remove(t.addr)
+	close(t.packets)
+	return nil
+}
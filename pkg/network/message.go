@@ -1,8 +1,11 @@
 package network
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
+
 	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
 )
 
@@ -15,14 +18,45 @@ const (
 	FIND_NODE
 	STORE
 	FIND_VALUE
+	STORE_RECORD
+	DELETE
 )
 
 // Message represents a Kademlia message.
+// Nodes with an identity sign their messages and send their public key and
+// dynamic puzzle nonce along, so the receiver can verify the SenderID.
 type Message struct {
-	RPCID    *dht.KademliaID
-	SenderID *dht.KademliaID
-	Type     MessageType
-	Payload  []byte
+	RPCID     *dht.KademliaID
+	SenderID  *dht.KademliaID
+	Type      MessageType
+	Payload   []byte
+	PublicKey []byte          `json:",omitempty"`
+	Nonce     *dht.KademliaID `json:",omitempty"`
+	Signature []byte          `json:",omitempty"`
+}
+
+// StorePayload is the payload of a STORE request. Publications attribute the
+// value to the nodes allowed to delete it, if the sender knows them. Cache is
+// set when the value is cached along a lookup path rather than published,
+// handed off or republished to one of the k closest nodes.
+type StorePayload struct {
+	Key          *dht.KademliaID
+	Value        []byte
+	Publications []dht.Publication `json:",omitempty"`
+	Cache        bool              `json:",omitempty"`
+}
+
+// StoreResultPayload is the payload of a STORE, STORE_RECORD or DELETE response.
+// Error is set if the request was rejected, for example because the store is full.
+type StoreResultPayload struct {
+	Error string `json:",omitempty"`
+}
+
+// FindValuePayload is the payload of a FIND_VALUE response. Value is set if the
+// responding node holds the key, otherwise Contacts lists closer nodes.
+type FindValuePayload struct {
+	Value    []byte        `json:",omitempty"`
+	Contacts []dht.Contact `json:",omitempty"`
 }
 
 // Serialize converts a Message to a byte slice for network transmission.
@@ -30,6 +64,48 @@ func (m *Message) Serialize() ([]byte, error) {
 	return json.Marshal(m)
 }
 
+// signedData returns the bytes covered by the signature: the message without it.
+func (m *Message) signedData() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// Sign sets the public key, nonce and signature of the message from identity.
+func (m *Message) Sign(identity *dht.Identity) error {
+	m.PublicKey = identity.PublicKey
+	m.Nonce = identity.Nonce
+	data, err := m.signedData()
+	if err != nil {
+		return err
+	}
+	m.Signature = identity.Sign(data)
+	return nil
+}
+
+// Verify checks that the message was signed by the owner of SenderID and that
+// SenderID solves the crypto puzzles of config. Unsigned messages are only
+// accepted when config requires no puzzle.
+func (m *Message) Verify(config dht.Config) error {
+	if m.PublicKey == nil {
+		if config.StaticPuzzle > 0 || config.DynamicPuzzle > 0 {
+			return errors.New("message is not signed")
+		}
+		return nil
+	}
+	if err := dht.VerifyIdentity(m.SenderID, m.PublicKey, m.Nonce, config); err != nil {
+		return err
+	}
+	data, err := m.signedData()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(m.PublicKey, data, m.Signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
 // Deserialize converts a byte slice back to a Message.
 func Deserialize(data []byte) (*Message, error) {
 	var msg Message
@@ -50,6 +126,10 @@ func (mt MessageType) String() string {
 		return "STORE"
 	case FIND_VALUE:
 		return "FIND_VALUE"
+	case STORE_RECORD:
+		return "STORE_RECORD"
+	case DELETE:
+		return "DELETE"
 	default:
 		return fmt.Sprintf("UNKNOWN(%d)", mt)
 	}
@@ -0,0 +1,15 @@
+// pkg/network/metrics.go
+package network
+
+import (
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/metrics"
+)
+
+var (
+	rpcSent        = metrics.NewCounterVec("kademlia_rpc_sent_total", "Messages sent, by message type.", "type")
+	rpcReceived    = metrics.NewCounterVec("kademlia_rpc_received_total", "Messages received, by message type.", "type")
+	rpcTimeouts    = metrics.NewCounterVec("kademlia_rpc_timeouts_total", "Requests that got no response in time, by message type.", "type")
+	rpcUnverified  = metrics.NewCounter("kademlia_rpc_unverified_total", "Messages whose sender failed identity verification and was not added to the routing table.")
+	rpcUnconfirmed = metrics.NewCounter("kademlia_rpc_unconfirmed_total", "Request senders not added to the routing table because they did not answer a ping at the address the request came from.")
+	rpcDuration    = metrics.NewHistogramVec("kademlia_rpc_duration_seconds", "Time from sending a request to receiving its response, by message type.", metrics.DefaultBuckets, "type")
+)
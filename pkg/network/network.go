@@ -4,75 +4,108 @@ package network
 import (
 	"encoding/json"
 	"errors"
-	"log"
-	"net"
+	"fmt"
 	"sync"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
 )
 
-const rpcTimeout = 5 * time.Second
+// DefaultTimeout is how long a request waits for its response unless SetTimeout is called.
+const DefaultTimeout = 5 * time.Second
 
-// Network handles the UDP communication between nodes.
+// Network implements the Kademlia protocol on top of a Transport.
 type Network struct {
 	NodeID           *dht.KademliaID
-	ListenAddr       string
-	conn             *net.UDPConn
+	transport        Transport
 	routingTable     *dht.RoutingTable
+	store            *dht.Store
 	mutex            sync.RWMutex
 	pendingResponses map[dht.KademliaID]chan *Message
+	confirming       map[dht.KademliaID]bool
+	timeout          time.Duration
+	identity         *dht.Identity
+	logger           *log.Entry
 }
 
-// NewNetwork creates a new Network instance.
-func NewNetwork(nodeID *dht.KademliaID, rt *dht.RoutingTable, listenAddr string) *Network {
+// NewNetwork creates a new Network instance that communicates over transport.
+func NewNetwork(nodeID *dht.KademliaID, rt *dht.RoutingTable, store *dht.Store, transport Transport) *Network {
 	return &Network{
 		NodeID:           nodeID,
-		ListenAddr:       listenAddr,
+		transport:        transport,
 		routingTable:     rt,
+		store:            store,
 		pendingResponses: make(map[dht.KademliaID]chan *Message),
+		confirming:       make(map[dht.KademliaID]bool),
+		timeout:          DefaultTimeout,
+		logger:           log.WithFields(log.Fields{"subsystem": "network", "node": nodeID.String()}),
 	}
 }
 
-// Listen starts the UDP listener for incoming messages.
-func (n *Network) Listen() {
-	addr, err := net.ResolveUDPAddr("udp", n.ListenAddr)
-	if err != nil {
-		log.Fatalf("Failed to resolve UDP address: %v", err)
-	}
+// LocalAddr returns the address other nodes use to reach this node.
+func (n *Network) LocalAddr() string {
+	return n.transport.LocalAddr()
+}
 
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		log.Fatalf("Failed to listen on UDP address: %v", err)
-	}
-	n.conn = conn
-	log.Printf("Listening on %s\n", n.ListenAddr)
+// SetTimeout sets how long requests wait for their response. It must not be called while requests are in flight.
+func (n *Network) SetTimeout(timeout time.Duration) {
+	n.timeout = timeout
+}
+
+// SetIdentity makes the Network sign its messages with identity, whose ID must be the node ID.
+// It must be called before Listen.
+func (n *Network) SetIdentity(identity *dht.Identity) {
+	n.identity = identity
+}
+
+// Identity returns the identity set with SetIdentity, or nil.
+func (n *Network) Identity() *dht.Identity {
+	return n.identity
+}
+
+// PendingResponses returns the number of requests waiting for a response.
+func (n *Network) PendingResponses() int {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return len(n.pendingResponses)
+}
+
+// Listen starts processing incoming messages from the transport.
+func (n *Network) Listen() {
+	n.logger.WithField("address", n.transport.LocalAddr()).Info("Listening")
 
 	go func() {
-		defer conn.Close()
-		buffer := make([]byte, 4096) // Increased buffer size for larger payloads
-		for {
-			length, remote, err := conn.ReadFromUDP(buffer)
-			if err != nil {
-				log.Printf("Error reading from UDP: %v", err)
-				continue
-			}
-			go n.handleMessage(buffer[:length], remote)
+		for packet := range n.transport.Receive() {
+			go n.handleMessage(packet.Data, packet.From)
 		}
 	}()
 }
 
+// Close shuts down the underlying transport.
+func (n *Network) Close() error {
+	return n.transport.Close()
+}
+
 // handleMessage deserializes and processes an incoming message.
-func (n *Network) handleMessage(data []byte, remote *net.UDPAddr) {
+func (n *Network) handleMessage(data []byte, remote string) {
 	msg, err := Deserialize(data)
 	if err != nil {
-		log.Printf("Error deserializing message from %s: %v", remote, err)
+		n.logger.WithFields(log.Fields{"peer": remote, "error": err}).Warn("Failed to deserialize message")
+		return
+	}
+	if msg.RPCID == nil || msg.SenderID == nil {
+		n.logger.WithField("peer", remote).Warn("Dropping malformed message")
 		return
 	}
 
-	// Add the sender to the routing table.
-	senderContact := dht.NewContact(msg.SenderID, remote.String())
-	n.routingTable.AddContact(senderContact, n)
+	rpcReceived.WithLabelValues(msg.Type.String()).Inc()
+	logger := n.logger.WithFields(log.Fields{
+		"peer":    remote,
+		"rpc_id":  msg.RPCID.String(),
+		"type":    msg.Type.String(),
+		"peer_id": msg.SenderID.String(),
+	})
 
 	// Check if this is a response to a pending RPC
 	n.mutex.RLock()
@@ -80,12 +113,38 @@ func (n *Network) handleMessage(data []byte, remote *net.UDPAddr) {
 	n.mutex.RUnlock()
 
 	if isResponse {
-		responseChan <- msg
+		// Deliver the response before touching the routing table, since adding
+		// the sender may block on a ping that is waiting for this very response.
+		select {
+		case responseChan <- msg:
+		default:
+		}
+	}
+
+	// Add the sender to the routing table, unless a lookup made us query
+	// ourselves or it cannot prove that it owns a valid ID. The signature of a
+	// response covers the RPCID we picked, but a signed request can be
+	// replayed from any address, so a new sender of a request is only added
+	// once it answers a ping at the address the request came from.
+	if err := msg.Verify(n.routingTable.Config()); err != nil {
+		rpcUnverified.Inc()
+		logger.WithError(err).Debug("Not adding unverified sender")
+	} else if !msg.SenderID.Equals(n.NodeID) {
+		senderContact := dht.NewContact(msg.SenderID, remote)
+		if isResponse || n.routingTable.Contains(senderContact) {
+			n.routingTable.AddContact(senderContact, n)
+		} else {
+			go n.confirmSender(senderContact)
+		}
+	}
+
+	if isResponse {
+		logger.Debug("Received response")
 		return
 	}
 
 	// Otherwise, handle it as a new request
-	log.Printf("Received %s from %s", msg.Type, remote)
+	logger.Debug("Received request")
 
 	switch msg.Type {
 	case PING:
@@ -98,13 +157,13 @@ func (n *Network) handleMessage(data []byte, remote *net.UDPAddr) {
 	case FIND_NODE:
 		var targetID dht.KademliaID
 		if err := json.Unmarshal(msg.Payload, &targetID); err != nil {
-			log.Printf("Failed to unmarshal FIND_NODE payload: %v", err)
+			logger.WithError(err).Warn("Failed to unmarshal FIND_NODE payload")
 			return
 		}
-		closestContacts := n.routingTable.FindClosestContacts(&targetID, dht.BucketSize)
+		closestContacts := n.routingTable.FindClosestContacts(&targetID, n.routingTable.Config().K)
 		payload, err := json.Marshal(closestContacts)
 		if err != nil {
-			log.Printf("Failed to marshal closest contacts: %v", err)
+			logger.WithError(err).Error("Failed to marshal closest contacts")
 			return
 		}
 		responseMsg := Message{
@@ -114,37 +173,156 @@ func (n *Network) handleMessage(data []byte, remote *net.UDPAddr) {
 			Payload:  payload,
 		}
 		n.sendMessage(&responseMsg, remote)
+	case STORE:
+		var request StorePayload
+		if err := json.Unmarshal(msg.Payload, &request); err != nil || request.Key == nil {
+			logger.WithError(err).Warn("Failed to unmarshal STORE payload")
+			return
+		}
+		var response StoreResultPayload
+		if err := n.put(&request); err != nil {
+			logger.WithError(err).Debug("Rejected value")
+			response.Error = err.Error()
+		}
+		payload, err := json.Marshal(response)
+		if err != nil {
+			logger.WithError(err).Error("Failed to marshal STORE response")
+			return
+		}
+		responseMsg := Message{
+			RPCID:    msg.RPCID,
+			SenderID: n.NodeID,
+			Type:     STORE,
+			Payload:  payload,
+		}
+		n.sendMessage(&responseMsg, remote)
+	case STORE_RECORD:
+		var record dht.Record
+		if err := json.Unmarshal(msg.Payload, &record); err != nil {
+			logger.WithError(err).Warn("Failed to unmarshal STORE_RECORD payload")
+			return
+		}
+		var response StoreResultPayload
+		if err := n.store.PutRecord(&record, dht.ValueTTL); err != nil {
+			logger.WithError(err).Debug("Rejected record")
+			response.Error = err.Error()
+		}
+		payload, err := json.Marshal(response)
+		if err != nil {
+			logger.WithError(err).Error("Failed to marshal STORE_RECORD response")
+			return
+		}
+		responseMsg := Message{
+			RPCID:    msg.RPCID,
+			SenderID: n.NodeID,
+			Type:     STORE_RECORD,
+			Payload:  payload,
+		}
+		n.sendMessage(&responseMsg, remote)
+	case DELETE:
+		var tombstone dht.Tombstone
+		if err := json.Unmarshal(msg.Payload, &tombstone); err != nil {
+			logger.WithError(err).Warn("Failed to unmarshal DELETE payload")
+			return
+		}
+		var response StoreResultPayload
+		if err := n.store.Delete(&tombstone); err != nil {
+			logger.WithError(err).Debug("Rejected tombstone")
+			response.Error = err.Error()
+		}
+		payload, err := json.Marshal(response)
+		if err != nil {
+			logger.WithError(err).Error("Failed to marshal DELETE response")
+			return
+		}
+		responseMsg := Message{
+			RPCID:    msg.RPCID,
+			SenderID: n.NodeID,
+			Type:     DELETE,
+			Payload:  payload,
+		}
+		n.sendMessage(&responseMsg, remote)
+	case FIND_VALUE:
+		var key dht.KademliaID
+		if err := json.Unmarshal(msg.Payload, &key); err != nil {
+			logger.WithError(err).Warn("Failed to unmarshal FIND_VALUE payload")
+			return
+		}
+		var response FindValuePayload
+		if value, ok := n.store.Get(&key); ok {
+			response.Value = value
+		} else {
+			response.Contacts = n.routingTable.FindClosestContacts(&key, n.routingTable.Config().K)
+		}
+		payload, err := json.Marshal(response)
+		if err != nil {
+			logger.WithError(err).Error("Failed to marshal FIND_VALUE response")
+			return
+		}
+		responseMsg := Message{
+			RPCID:    msg.RPCID,
+			SenderID: n.NodeID,
+			Type:     FIND_VALUE,
+			Payload:  payload,
+		}
+		n.sendMessage(&responseMsg, remote)
 	default:
-		log.Printf("Received unknown message type %d from %s", msg.Type, remote)
+		logger.Warn("Received unknown message type")
 	}
 }
 
-// sendMessage serializes and sends a message to a remote address.
-func (n *Network) sendMessage(msg *Message, remote *net.UDPAddr) {
-	data, err := msg.Serialize()
-	if err != nil {
-		log.Printf("Error serializing message for %s: %v", remote, err)
+// confirmSender pings contact at its address. Its PONG is a response, which
+// adds it to the routing table if it is signed by contact.ID.
+func (n *Network) confirmSender(contact dht.Contact) {
+	n.mutex.Lock()
+	if n.confirming[*contact.ID] {
+		n.mutex.Unlock()
 		return
 	}
+	n.confirming[*contact.ID] = true
+	n.mutex.Unlock()
+	defer func() {
+		n.mutex.Lock()
+		delete(n.confirming, *contact.ID)
+		n.mutex.Unlock()
+	}()
 
-	_, err = n.conn.WriteToUDP(data, remote)
-	if err != nil {
-		log.Printf("Error sending message to %s: %v", remote, err)
+	claimed := *contact.ID
+	if err := n.Ping(&contact); err != nil || !contact.ID.Equals(&claimed) {
+		rpcUnconfirmed.Inc()
+		n.logger.WithFields(log.Fields{"peer": contact.Address, "peer_id": claimed.String(), "error": err}).Debug("Not adding sender that did not answer at its address")
 	}
 }
 
-// FindNode sends a FIND_NODE request and waits for a response.
-func (n *Network) FindNode(contact *dht.Contact, target *dht.KademliaID) ([]dht.Contact, error) {
-	rpcID := dht.NewRandomKademliaID()
-	payload, err := json.Marshal(target)
+// sendMessage signs, serializes and sends a message to a remote address.
+func (n *Network) sendMessage(msg *Message, remote string) error {
+	if n.identity != nil {
+		if err := msg.Sign(n.identity); err != nil {
+			n.logger.WithFields(log.Fields{"peer": remote, "type": msg.Type.String(), "error": err}).Error("Failed to sign message")
+			return err
+		}
+	}
+	data, err := msg.Serialize()
 	if err != nil {
-		return nil, err
+		n.logger.WithFields(log.Fields{"peer": remote, "type": msg.Type.String(), "error": err}).Error("Failed to serialize message")
+		return err
+	}
+
+	if err := n.transport.Send(remote, data); err != nil {
+		n.logger.WithFields(log.Fields{"peer": remote, "type": msg.Type.String(), "error": err}).Warn("Failed to send message")
+		return err
 	}
+	rpcSent.WithLabelValues(msg.Type.String()).Inc()
+	return nil
+}
 
+// sendRequest sends a request to contact and waits for the matching response.
+func (n *Network) sendRequest(contact *dht.Contact, msgType MessageType, payload []byte) (*Message, error) {
+	rpcID := dht.NewRandomKademliaID()
 	requestMsg := &Message{
 		RPCID:    rpcID,
 		SenderID: n.NodeID,
-		Type:     FIND_NODE,
+		Type:     msgType,
 		Payload:  payload,
 	}
 
@@ -159,63 +337,170 @@ func (n *Network) FindNode(contact *dht.Contact, target *dht.KademliaID) ([]dht.
 		n.mutex.Unlock()
 	}()
 
-	remoteAddr, err := net.ResolveUDPAddr("udp", contact.Address)
-	if err != nil {
+	sent := time.Now()
+	if err := n.sendMessage(requestMsg, contact.Address); err != nil {
 		return nil, err
 	}
 
-	n.sendMessage(requestMsg, remoteAddr)
-
 	select {
 	case responseMsg := <-responseChan:
-		var contacts []dht.Contact
-		if err := json.Unmarshal(responseMsg.Payload, &contacts); err != nil {
-			return nil, err
-		}
-		return contacts, nil
-	case <-time.After(rpcTimeout):
+		rpcDuration.WithLabelValues(msgType.String()).Observe(time.Since(sent).Seconds())
+		return responseMsg, nil
+	case <-time.After(n.timeout):
+		rpcTimeouts.WithLabelValues(msgType.String()).Inc()
+		n.logger.WithFields(log.Fields{
+			"peer":   contact.Address,
+			"rpc_id": rpcID.String(),
+			"type":   msgType.String(),
+		}).Debug("Request timed out")
 		return nil, errors.New("rpc timeout")
 	}
 }
 
+// FindNode sends a FIND_NODE request and waits for a response.
+func (n *Network) FindNode(contact *dht.Contact, target *dht.KademliaID) ([]dht.Contact, error) {
+	payload, err := json.Marshal(target)
+	if err != nil {
+		return nil, err
+	}
+
+	responseMsg, err := n.sendRequest(contact, FIND_NODE, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var contacts []dht.Contact
+	if err := json.Unmarshal(responseMsg.Payload, &contacts); err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
+
 // Ping sends a PING request and waits for a PONG response.
 func (n *Network) Ping(contact *dht.Contact) error {
-	rpcID := dht.NewRandomKademliaID()
+	responseMsg, err := n.sendRequest(contact, PING, nil)
+	if err != nil {
+		return err
+	}
 
-	requestMsg := &Message{
-		RPCID:    rpcID,
-		SenderID: n.NodeID,
-		Type:     PING,
+	if responseMsg.Type != PONG {
+		return errors.New("invalid response type for ping")
 	}
+	// The contact ID should be updated from the PONG response
+	contact.ID = responseMsg.SenderID
+	return nil
+}
 
-	responseChan := make(chan *Message, 1)
-	n.mutex.Lock()
-	n.pendingResponses[*rpcID] = responseChan
-	n.mutex.Unlock()
+// Store sends a STORE request with the signed publications of the value and
+// waits for the acknowledgement. It returns the contact's reason for rejecting
+// the value, if any.
+func (n *Network) Store(contact *dht.Contact, key *dht.KademliaID, value []byte, publications []dht.Publication) error {
+	payload, err := json.Marshal(StorePayload{Key: key, Value: value, Publications: publications})
+	if err != nil {
+		return err
+	}
 
-	defer func() {
-		n.mutex.Lock()
-		delete(n.pendingResponses, *rpcID)
-		n.mutex.Unlock()
-	}()
+	responseMsg, err := n.sendRequest(contact, STORE, payload)
+	if err != nil {
+		return err
+	}
+	if responseMsg.Type != STORE {
+		return errors.New("invalid response type for store")
+	}
+	return storeResult(responseMsg.Payload)
+}
 
-	remoteAddr, err := net.ResolveUDPAddr("udp", contact.Address)
+// Cache sends a STORE request marked for caching. The contact keeps the value
+// for a time scaled by its distance from key.
+func (n *Network) Cache(contact *dht.Contact, key *dht.KademliaID, value []byte) error {
+	payload, err := json.Marshal(StorePayload{Key: key, Value: value, Cache: true})
 	if err != nil {
 		return err
 	}
 
-	n.sendMessage(requestMsg, remoteAddr)
+	responseMsg, err := n.sendRequest(contact, STORE, payload)
+	if err != nil {
+		return err
+	}
+	if responseMsg.Type != STORE {
+		return errors.New("invalid response type for store")
+	}
+	return storeResult(responseMsg.Payload)
+}
 
-	select {
-	case responseMsg := <-responseChan:
-		if responseMsg.Type == PONG {
-			// The contact ID should be updated from the PONG response
-			contact.ID = responseMsg.SenderID
-			return nil
-		} else {
-			return errors.New("invalid response type for ping")
-		}
-	case <-time.After(rpcTimeout):
-		return errors.New("rpc timeout for ping")
+// put stores the value of a STORE request. Values sent for caching are kept
+// for a shorter time the farther this node is from the key, and cached records
+// are stored as records, so that they keep their versioning.
+func (n *Network) put(request *StorePayload) error {
+	if !request.Cache {
+		return n.store.PutFrom(request.Key, request.Value, dht.ValueTTL, request.Publications)
 	}
-}
\ No newline at end of file
+	return n.store.Cache(request.Key, request.Value, dht.StoreTTL(n.routingTable, request.Key))
+}
+
+// StoreRecord sends a STORE_RECORD request and returns the contact's reason
+// for rejecting the record, if any.
+func (n *Network) StoreRecord(contact *dht.Contact, record *dht.Record) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	responseMsg, err := n.sendRequest(contact, STORE_RECORD, payload)
+	if err != nil {
+		return err
+	}
+	if responseMsg.Type != STORE_RECORD {
+		return errors.New("invalid response type for store record")
+	}
+	return storeResult(responseMsg.Payload)
+}
+
+// Delete sends a DELETE request with a tombstone and returns the contact's
+// reason for rejecting it, if any.
+func (n *Network) Delete(contact *dht.Contact, tombstone *dht.Tombstone) error {
+	payload, err := json.Marshal(tombstone)
+	if err != nil {
+		return err
+	}
+
+	responseMsg, err := n.sendRequest(contact, DELETE, payload)
+	if err != nil {
+		return err
+	}
+	if responseMsg.Type != DELETE {
+		return errors.New("invalid response type for delete")
+	}
+	return storeResult(responseMsg.Payload)
+}
+
+// storeResult turns the payload of a STORE, STORE_RECORD or DELETE response into an error.
+func storeResult(payload []byte) error {
+	var response StoreResultPayload
+	if err := json.Unmarshal(payload, &response); err != nil {
+		return err
+	}
+	if response.Error != "" {
+		return fmt.Errorf("rejected: %s", response.Error)
+	}
+	return nil
+}
+
+// FindValue sends a FIND_VALUE request and waits for either the value or closer contacts.
+func (n *Network) FindValue(contact *dht.Contact, key *dht.KademliaID) ([]byte, []dht.Contact, error) {
+	payload, err := json.Marshal(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	responseMsg, err := n.sendRequest(contact, FIND_VALUE, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var response FindValuePayload
+	if err := json.Unmarshal(responseMsg.Payload, &response); err != nil {
+		return nil, nil, err
+	}
+	return response.Value, response.Contacts, nil
+}
@@ -0,0 +1,597 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+)
+
+// newTestNode creates a listening Network attached to the in-memory network.
+func newTestNode(t *testing.T, mn *MemoryNetwork, addr string) *Network {
+	t.Helper()
+	transport, err := mn.Listen(addr)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	nodeID := dht.NewRandomKademliaID()
+	rt := dht.NewRoutingTable(dht.NewContact(nodeID, addr), dht.DefaultConfig())
+	n := NewNetwork(nodeID, rt, dht.NewStore(), transport)
+	n.Listen()
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// waitForContact waits until n has the node with id in its routing table.
+func waitForContact(t *testing.T, n *Network, id *dht.KademliaID) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if closest := n.routingTable.FindClosestContacts(id, 1); len(closest) == 1 && closest[0].ID.Equals(id) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %s to learn about %s", n.NodeID, id)
+}
+
+func TestPingOverMemoryTransport(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	b := newTestNode(t, mn, "node-b")
+
+	// The ID is unknown up front and should be learned from the PONG.
+	contact := dht.NewContact(dht.NewRandomKademliaID(), b.LocalAddr())
+	if err := a.Ping(&contact); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if !contact.ID.Equals(b.NodeID) {
+		t.Errorf("Expected contact ID %s but got %s", b.NodeID, contact.ID)
+	}
+
+	// b should learn about a from the PING, once a answered b's own ping.
+	waitForContact(t, b, a.NodeID)
+}
+
+func TestFindNodeOverMemoryTransport(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	b := newTestNode(t, mn, "node-b")
+
+	// Seed b with a few contacts that a does not know about.
+	for i := 0; i < 5; i++ {
+		contact := dht.NewContact(dht.NewRandomKademliaID(), fmt.Sprintf("peer-%d", i))
+		b.routingTable.AddContact(contact, b)
+	}
+
+	contactB := dht.NewContact(b.NodeID, b.LocalAddr())
+	if err := a.Ping(&contactB); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	waitForContact(t, b, a.NodeID)
+
+	target := dht.NewRandomKademliaID()
+	contacts, err := a.FindNode(&contactB, target)
+	if err != nil {
+		t.Fatalf("FindNode failed: %v", err)
+	}
+	// The response also includes a itself, which b learned from the PING.
+	if len(contacts) != 6 {
+		t.Errorf("Expected 6 contacts but got %d", len(contacts))
+	}
+}
+
+func TestStoreAndFindValueOverMemoryTransport(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	b := newTestNode(t, mn, "node-b")
+	contactB := dht.NewContact(b.NodeID, b.LocalAddr())
+
+	if err := a.Ping(&contactB); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	waitForContact(t, b, a.NodeID)
+
+	data := []byte("hello kademlia")
+	key := dht.NewKademliaIDFromData(data)
+
+	// Before the STORE, b answers with contacts instead of the value.
+	value, contacts, err := a.FindValue(&contactB, key)
+	if err != nil {
+		t.Fatalf("FindValue failed: %v", err)
+	}
+	if value != nil || len(contacts) == 0 {
+		t.Errorf("Expected contacts and no value, got value %q and %d contacts", value, len(contacts))
+	}
+
+	if err := a.Store(&contactB, key, data, nil); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	value, _, err = a.FindValue(&contactB, key)
+	if err != nil {
+		t.Fatalf("FindValue failed: %v", err)
+	}
+	if string(value) != string(data) {
+		t.Errorf("Expected value %q but got %q", data, value)
+	}
+}
+
+func TestValuesAreHandedOffToCloserNewNode(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	dht.NewKademlia(a.routingTable, a, a.store, dht.DefaultConfig())
+
+	data := []byte("placed before the closer node joined")
+	key := dht.NewKademliaIDFromData(data)
+	a.store.Put(key, data, dht.ValueTTL)
+
+	// Give b an ID that differs from the key only in the last bit, so it is
+	// closer to the key than a is.
+	transport, err := mn.Listen("node-b")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	bID := *key
+	bID[dht.IDLength-1] ^= 1
+	bStore := dht.NewStore()
+	b := NewNetwork(&bID, dht.NewRoutingTable(dht.NewContact(&bID, "node-b"), dht.DefaultConfig()), bStore, transport)
+	b.Listen()
+	t.Cleanup(func() { b.Close() })
+
+	// Contacting a makes b show up in a's routing table.
+	contactA := dht.NewContact(a.NodeID, a.LocalAddr())
+	if err := b.Ping(&contactA); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if value, ok := bStore.Get(key); ok {
+			if string(value) != string(data) {
+				t.Fatalf("Expected %q but got %q", data, value)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected the value to be handed off to the closer node")
+}
+
+func TestCachedValuesAreHandedOffForCaching(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	dht.NewKademlia(a.routingTable, a, a.store, dht.DefaultConfig())
+
+	data := []byte("cached before the closer node joined")
+	key := dht.NewKademliaIDFromData(data)
+	a.store.Cache(key, data, time.Hour)
+
+	transport, err := mn.Listen("node-b")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	bID := *key
+	bID[dht.IDLength-1] ^= 1
+	bStore := dht.NewStore()
+	b := NewNetwork(&bID, dht.NewRoutingTable(dht.NewContact(&bID, "node-b"), dht.DefaultConfig()), bStore, transport)
+	b.Listen()
+	t.Cleanup(func() { b.Close() })
+
+	contactA := dht.NewContact(a.NodeID, a.LocalAddr())
+	if err := b.Ping(&contactA); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := bStore.Get(key); ok {
+			if !bStore.IsCached(key) {
+				t.Error("Expected a cached copy to be handed off as a cached copy")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected the cached value to be handed off to the closer node")
+}
+
+func TestFoundValuesAreCachedOnPath(t *testing.T) {
+	mn := NewMemoryNetwork()
+	holder := newTestNode(t, mn, "holder")
+	empty := newTestNode(t, mn, "empty")
+	reader := newTestNode(t, mn, "reader")
+	kademlia := dht.NewKademlia(reader.routingTable, reader, reader.store, dht.DefaultConfig())
+
+	data := []byte("worth caching")
+	key := dht.NewKademliaIDFromData(data)
+	holder.store.Put(key, data, dht.ValueTTL)
+	reader.routingTable.AddContact(dht.NewContact(holder.NodeID, holder.LocalAddr()), reader)
+	reader.routingTable.AddContact(dht.NewContact(empty.NodeID, empty.LocalAddr()), reader)
+
+	value, err := kademlia.Get(key)
+	if err != nil || string(value) != string(data) {
+		t.Fatalf("Expected %q but got %q, %v", data, value, err)
+	}
+
+	// Both were queried in the first round, and only one of them had the value.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := empty.store.Get(key); ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected the value to be cached on the node that did not have it")
+}
+
+func TestOnlyCachedValuesGetScaledTTLs(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	b := newTestNode(t, mn, "node-b")
+	contactB := dht.NewContact(b.NodeID, b.LocalAddr())
+
+	// b knows k neighbours right next to its own ID, so random keys are far away from it.
+	for i := 0; i < dht.DefaultConfig().K; i++ {
+		id := *b.NodeID
+		id[dht.IDLength-1] ^= byte(i + 1)
+		b.routingTable.AddContact(dht.NewContact(&id, fmt.Sprintf("10.%d.0.1:8000", i)), b)
+	}
+	ttl := func(key *dht.KademliaID) time.Duration {
+		expires, ok := b.store.Expires(key)
+		if !ok {
+			t.Fatalf("Expected %s to be stored", key)
+		}
+		return time.Until(expires)
+	}
+
+	published := []byte("published")
+	if err := a.Store(&contactB, dht.NewKademliaIDFromData(published), published, nil); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if remaining := ttl(dht.NewKademliaIDFromData(published)); remaining < dht.ValueTTL-time.Minute {
+		t.Errorf("Expected a published value to be kept for %v, got %v", dht.ValueTTL, remaining)
+	}
+
+	// The cache request re-stores the published value, which must not shorten its expiry.
+	if err := a.Cache(&contactB, dht.NewKademliaIDFromData(published), published); err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	if remaining := ttl(dht.NewKademliaIDFromData(published)); remaining < dht.ValueTTL-time.Minute {
+		t.Errorf("Expected caching not to shorten the expiry of a replica, got %v", remaining)
+	}
+
+	cached := []byte("cached")
+	if err := a.Cache(&contactB, dht.NewKademliaIDFromData(cached), cached); err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	if remaining := ttl(dht.NewKademliaIDFromData(cached)); remaining > dht.ValueTTL/2 {
+		t.Errorf("Expected a cached value far from its key to be kept for a shorter time, got %v", remaining)
+	}
+
+	identity, err := dht.NewIdentity(dht.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := dht.NewRecord(identity, "cached", 1, []byte("value"))
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Cache(&contactB, record.Key(), data); err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	if !b.store.IsRecord(record.Key()) {
+		t.Error("Expected a cached record to be stored as a record")
+	}
+}
+
+func TestGetRecordReturnsNewestVersion(t *testing.T) {
+	mn := NewMemoryNetwork()
+	outdated := newTestNode(t, mn, "outdated")
+	current := newTestNode(t, mn, "current")
+	reader := newTestNode(t, mn, "reader")
+	kademlia := dht.NewKademlia(reader.routingTable, reader, reader.store, dht.DefaultConfig())
+	owner, err := dht.NewIdentity(dht.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := dht.NewRecord(owner, "profile", 1, []byte("first"))
+	second := dht.NewRecord(owner, "profile", 2, []byte("second"))
+	for _, store := range []*dht.Store{outdated.store, reader.store} {
+		if err := store.PutRecord(first, dht.ValueTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := current.store.PutRecord(second, dht.ValueTTL); err != nil {
+		t.Fatal(err)
+	}
+	reader.routingTable.AddContact(dht.NewContact(outdated.NodeID, outdated.LocalAddr()), reader)
+	reader.routingTable.AddContact(dht.NewContact(current.NodeID, current.LocalAddr()), reader)
+
+	record, err := kademlia.GetRecord(first.Key())
+	if err != nil {
+		t.Fatalf("GetRecord failed: %v", err)
+	}
+	if record.Sequence != 2 || string(record.Value) != "second" {
+		t.Fatalf("Expected the second record, got sequence %d with %q", record.Sequence, record.Value)
+	}
+
+	// The node that returned the first record is sent the second one.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		data, _ := outdated.store.Get(first.Key())
+		if record, err := dht.DecodeRecord(first.Key(), data); err == nil && record.Sequence == 2 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected the outdated node to get the newest record")
+}
+
+func TestStoreReportsFullStore(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	b := newTestNode(t, mn, "node-b")
+	b.store.SetQuota(dht.Quota{MaxKeys: 1, Policy: dht.EvictNothing}, b.NodeID)
+	contactB := dht.NewContact(b.NodeID, b.LocalAddr())
+
+	first := []byte("fits")
+	if err := a.Store(&contactB, dht.NewKademliaIDFromData(first), first, nil); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	second := []byte("does not fit")
+	if err := a.Store(&contactB, dht.NewKademliaIDFromData(second), second, nil); err == nil {
+		t.Error("Expected the STORE to a full node to fail")
+	}
+}
+
+func TestStoreCannotClaimAnotherPublishersValue(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	b := newTestNode(t, mn, "node-b")
+	contactB := dht.NewContact(b.NodeID, b.LocalAddr())
+	publisher, _ := dht.NewIdentity(dht.DefaultConfig())
+	attacker, _ := dht.NewIdentity(dht.DefaultConfig())
+
+	data := []byte("published by someone else")
+	key := dht.NewKademliaIDFromData(data)
+	if err := b.store.PutFrom(key, data, dht.ValueTTL, []dht.Publication{*dht.NewPublication(publisher, key)}); err != nil {
+		t.Fatal(err)
+	}
+
+	forged := *dht.NewPublication(attacker, key)
+	forged.PublicKey = publisher.PublicKey
+	if err := a.Store(&contactB, key, data, []dht.Publication{forged}); err == nil {
+		t.Error("Expected a STORE with a forged publication to be rejected")
+	}
+	if err := a.Store(&contactB, key, data, []dht.Publication{*dht.NewPublication(attacker, key)}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if err := a.Delete(&contactB, dht.NewTombstone(attacker, key)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok := b.store.Get(key); !ok {
+		t.Error("Expected the value to outlive the attacker's tombstone")
+	}
+	publications := b.store.Publications(key)
+	if len(publications) != 1 || !publications[0].PublicKey.Equal(publisher.PublicKey) {
+		t.Errorf("Expected only the original publisher to be left, got %v", publications)
+	}
+}
+
+func TestRequestToUnknownAddressTimesOut(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	a.SetTimeout(100 * time.Millisecond)
+
+	contact := dht.NewContact(dht.NewRandomKademliaID(), "nowhere")
+	if err := a.Ping(&contact); err == nil {
+		t.Error("Expected ping to an unknown address to fail")
+	}
+}
+
+func TestMessageIDsKeepTheirWireEncoding(t *testing.T) {
+	// IDs travel as JSON byte arrays, so that nodes running older versions understand them.
+	msg := Message{RPCID: dht.NewRandomKademliaID(), SenderID: dht.NewKademliaID("ffffffff00000000000000000000000000000000")}
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"SenderID":[255,255,255,255,0,`)) {
+		t.Errorf("Expected the sender ID as a byte array, got %s", data)
+	}
+}
+
+func TestLargestRecordFitsIntoADatagram(t *testing.T) {
+	identity, err := dht.NewIdentity(dht.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.Repeat("n", dht.MaxRecordName)
+	record, err := json.Marshal(dht.NewRecord(identity, name, 1, make([]byte, dht.MaxRecordValue)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A FIND_VALUE response encodes the record twice more, once in its payload and once in the message.
+	payload, err := json.Marshal(FindValuePayload{Value: record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{RPCID: dht.NewRandomKademliaID(), SenderID: identity.ID, Type: FIND_VALUE, Payload: payload}
+	if err := msg.Sign(identity); err != nil {
+		t.Fatal(err)
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > maxDatagramSize {
+		t.Errorf("Expected the response to fit into %d bytes but it takes %d", maxDatagramSize, len(data))
+	}
+}
+
+func TestMemoryTransportDropsPacketsWhenFull(t *testing.T) {
+	mn := NewMemoryNetwork()
+	sender, err := mn.Listen("sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := mn.Listen("receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody reads from the receiver, so sending must not block once its buffer is full.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*cap(receiver.packets); i++ {
+			sender.Send("receiver", []byte("packet"))
+		}
+		receiver.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected sends to a full transport not to block")
+	}
+}
+
+func TestUnverifiedSendersAreNotAdded(t *testing.T) {
+	mn := NewMemoryNetwork()
+	config := dht.DefaultConfig()
+	config.StaticPuzzle = 4
+
+	newNode := func(addr string, identity *dht.Identity) *Network {
+		transport, err := mn.Listen(addr)
+		if err != nil {
+			t.Fatalf("Failed to listen on %s: %v", addr, err)
+		}
+		nodeID := dht.NewRandomKademliaID()
+		if identity != nil {
+			nodeID = identity.ID
+		}
+		n := NewNetwork(nodeID, dht.NewRoutingTable(dht.NewContact(nodeID, addr), config), dht.NewStore(), transport)
+		if identity != nil {
+			n.SetIdentity(identity)
+		}
+		n.Listen()
+		t.Cleanup(func() { n.Close() })
+		return n
+	}
+	newIdentity := func() *dht.Identity {
+		identity, err := dht.NewIdentity(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return identity
+	}
+
+	a := newNode("node-a", newIdentity())
+	honest := newNode("honest", newIdentity())
+	sybil := newNode("sybil", nil)
+
+	contactA := dht.NewContact(a.NodeID, a.LocalAddr())
+	if err := honest.Ping(&contactA); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	// a still answers the unverified node, it just does not remember it.
+	if err := sybil.Ping(&contactA); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	waitForContact(t, a, honest.NodeID)
+	contacts := a.routingTable.FindClosestContacts(dht.NewRandomKademliaID(), 10)
+	if len(contacts) != 1 || !contacts[0].ID.Equals(honest.NodeID) {
+		t.Errorf("Expected only the verified node in the routing table, got %v", contacts)
+	}
+}
+
+func TestReplayedRequestsDoNotAddTheirSender(t *testing.T) {
+	mn := NewMemoryNetwork()
+	a := newTestNode(t, mn, "node-a")
+	a.SetTimeout(50 * time.Millisecond)
+	victim, err := dht.NewIdentity(dht.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attacker, err := mn.Listen("attacker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer attacker.Close()
+
+	// A request signed by the victim, captured and sent again from another address.
+	captured := &Message{RPCID: dht.NewRandomKademliaID(), SenderID: victim.ID, Type: PING}
+	if err := captured.Sign(victim); err != nil {
+		t.Fatal(err)
+	}
+	data, err := captured.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := attacker.Send(a.LocalAddr(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pings the attacker's address to confirm the sender, which the attacker
+	// cannot answer as the victim.
+	select {
+	case <-attacker.Receive():
+	case <-time.After(time.Second):
+		t.Fatal("Expected a to ping the address the request came from")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if contacts := a.routingTable.FindClosestContacts(victim.ID, 1); len(contacts) != 0 {
+		t.Errorf("Expected the replayed sender not to be added, got %v", contacts)
+	}
+}
+
+func TestLargeObjectsAreChunkedOverUDP(t *testing.T) {
+	newNode := func() (*Network, *dht.Kademlia) {
+		transport, err := NewUDPTransport("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		nodeID := dht.NewRandomKademliaID()
+		rt := dht.NewRoutingTable(dht.NewContact(nodeID, transport.LocalAddr()), dht.DefaultConfig())
+		store := dht.NewStore()
+		n := NewNetwork(nodeID, rt, store, transport)
+		n.SetTimeout(time.Second)
+		n.Listen()
+		t.Cleanup(func() { n.Close() })
+		return n, dht.NewKademlia(rt, n, store, dht.DefaultConfig())
+	}
+	a, kademliaA := newNode()
+	b, kademliaB := newNode()
+	contactB := dht.NewContact(b.NodeID, b.LocalAddr())
+	if err := a.Ping(&contactB); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	data := make([]byte, 3*dht.ChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	root, err := kademliaA.PutObject(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if root.Equals(dht.NewKademliaIDFromData(data)) {
+		t.Error("Expected a large object to be stored under its Merkle root")
+	}
+
+	// Every chunk reached b in a STORE message that had to fit into one datagram.
+	var fetched bytes.Buffer
+	if err := kademliaB.GetObject(root, &fetched); err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	if !bytes.Equal(fetched.Bytes(), data) {
+		t.Errorf("Expected %d bytes back, got %d different ones", len(data), fetched.Len())
+	}
+}
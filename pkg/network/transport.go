@@ -0,0 +1,23 @@
+// pkg/network/transport.go
+package network
+
+// Packet is a single datagram delivered by a Transport.
+type Packet struct {
+	Data []byte
+	From string
+}
+
+// Transport moves raw message bytes between nodes. It knows nothing about
+// the Kademlia protocol, which lets the same Network run over UDP, an
+// in-memory pipe for tests, or any other datagram-like medium.
+type Transport interface {
+	// Send delivers data to the node listening on addr.
+	Send(addr string, data []byte) error
+	// Receive returns the channel on which incoming packets are delivered.
+	// The channel is closed when the transport is closed.
+	Receive() <-chan Packet
+	// LocalAddr returns the address other nodes use to reach this transport.
+	LocalAddr() string
+	// Close stops the transport and releases its resources.
+	Close() error
+}
@@ -0,0 +1,89 @@
+// pkg/network/udp.go
+package network
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxDatagramSize is the largest payload a single UDP datagram can carry.
+const maxDatagramSize = 65507
+
+// UDPTransport is a Transport backed by a UDP socket.
+type UDPTransport struct {
+	conn      *net.UDPConn
+	packets   chan Packet
+	closeOnce sync.Once
+}
+
+// NewUDPTransport binds a UDP socket on listenAddr and starts reading from it.
+func NewUDPTransport(listenAddr string) (*UDPTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &UDPTransport{
+		conn:    conn,
+		packets: make(chan Packet, 64),
+	}
+	go t.readLoop()
+	return t, nil
+}
+
+// readLoop reads datagrams from the socket until it is closed.
+func (t *UDPTransport) readLoop() {
+	defer close(t.packets)
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		length, remote, err := t.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.WithFields(log.Fields{"subsystem": "transport", "error": err}).Warn("Failed to read from UDP")
+			continue
+		}
+		// Copy the datagram, the buffer is reused for the next read.
+		data := make([]byte, length)
+		copy(data, buffer[:length])
+		t.packets <- Packet{Data: data, From: remote.String()}
+	}
+}
+
+// Send writes data as a single datagram to addr.
+func (t *UDPTransport) Send(addr string, data []byte) error {
+	remote, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = t.conn.WriteToUDP(data, remote)
+	return err
+}
+
+// Receive returns the channel of incoming datagrams.
+func (t *UDPTransport) Receive() <-chan Packet {
+	return t.packets
+}
+
+// LocalAddr returns the address the socket is bound to.
+func (t *UDPTransport) LocalAddr() string {
+	return t.conn.LocalAddr().String()
+}
+
+// Close closes the underlying socket.
+func (t *UDPTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		err = t.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,302 @@
+// pkg/node/config.go
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the config file
+const EnvPrefix = "KADEMLIA_"
+
+// Duration is a time.Duration written as a string such as "5s" in config files
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config is the configuration of a node, as read from a config file
+type Config struct {
+	// Listen is the UDP address the node binds to
+	Listen string `json:"listen" yaml:"listen" toml:"listen"`
+	// Advertise is the address other nodes reach this node on, it defaults to Listen
+	Advertise string `json:"advertise,omitempty" yaml:"advertise,omitempty" toml:"advertise,omitempty"`
+	// Bootstrap are the addresses of nodes to join the network through
+	Bootstrap []string `json:"bootstrap,omitempty" yaml:"bootstrap,omitempty" toml:"bootstrap,omitempty"`
+	// DataDir is where the node keeps its key across restarts, empty means a new identity every run
+	DataDir string `json:"data_dir,omitempty" yaml:"data_dir,omitempty" toml:"data_dir,omitempty"`
+	K       int    `json:"k" yaml:"k" toml:"k"`
+	Alpha   int    `json:"alpha" yaml:"alpha" toml:"alpha"`
+	// DisjointPaths is the number of disjoint paths lookups run over, 1 disables them
+	DisjointPaths int            `json:"disjoint_paths" yaml:"disjoint_paths" toml:"disjoint_paths"`
+	Puzzles       PuzzlesConfig  `json:"puzzles" yaml:"puzzles" toml:"puzzles"`
+	IPLimits      IPLimitsConfig `json:"ip_limits" yaml:"ip_limits" toml:"ip_limits"`
+	Timeouts      TimeoutsConfig `json:"timeouts" yaml:"timeouts" toml:"timeouts"`
+	Storage       StorageConfig  `json:"storage" yaml:"storage" toml:"storage"`
+	API           APIConfig      `json:"api" yaml:"api" toml:"api"`
+	Log           LogConfig      `json:"log" yaml:"log" toml:"log"`
+}
+
+// PuzzlesConfig sets the difficulty of the crypto puzzles node IDs must solve, in leading zero bits
+type PuzzlesConfig struct {
+	Static  int `json:"static" yaml:"static" toml:"static"`
+	Dynamic int `json:"dynamic" yaml:"dynamic" toml:"dynamic"`
+}
+
+// IPLimitsConfig caps how many contacts may share an IP address or a /24
+// (IPv6 /64) subnet, per bucket and in the whole routing table. 0 means no limit.
+type IPLimitsConfig struct {
+	BucketIP     int `json:"bucket_ip" yaml:"bucket_ip" toml:"bucket_ip"`
+	BucketSubnet int `json:"bucket_subnet" yaml:"bucket_subnet" toml:"bucket_subnet"`
+	TableIP      int `json:"table_ip" yaml:"table_ip" toml:"table_ip"`
+	TableSubnet  int `json:"table_subnet" yaml:"table_subnet" toml:"table_subnet"`
+}
+
+// TimeoutsConfig holds the timers of a node
+type TimeoutsConfig struct {
+	// RPC is how long to wait for a response to a request
+	RPC Duration `json:"rpc" yaml:"rpc" toml:"rpc"`
+	// Republish is how often published values are stored again
+	Republish Duration `json:"republish" yaml:"republish" toml:"republish"`
+}
+
+// StorageConfig selects where values are stored and how much of them
+type StorageConfig struct {
+	// Backend is the storage backend, only "memory" is supported
+	Backend string `json:"backend" yaml:"backend" toml:"backend"`
+	// MaxBytes and MaxKeys limit the values held for other nodes, 0 means no limit
+	MaxBytes int `json:"max_bytes" yaml:"max_bytes" toml:"max_bytes"`
+	MaxKeys  int `json:"max_keys" yaml:"max_keys" toml:"max_keys"`
+	// Eviction is what happens once a limit is reached: "reject" new values,
+	// or evict the "farthest" from the node's ID, the "lru" or the soonest to "expiry"
+	Eviction string `json:"eviction" yaml:"eviction" toml:"eviction"`
+}
+
+// APIConfig configures the HTTP control API
+type APIConfig struct {
+	// Listen is the address the API is served on, empty disables it
+	Listen string `json:"listen" yaml:"listen" toml:"listen"`
+}
+
+// LogConfig configures logging
+type LogConfig struct {
+	// Level is a logrus level such as "info" or "debug"
+	Level string `json:"level" yaml:"level" toml:"level"`
+	// Format is either "text" or "json"
+	Format string `json:"format" yaml:"format" toml:"format"`
+}
+
+// DefaultConfig returns the configuration used when nothing else is given
+func DefaultConfig() Config {
+	dhtConfig := dht.DefaultConfig()
+	return Config{
+		Listen:        "127.0.0.1:8080",
+		K:             dhtConfig.K,
+		Alpha:         dhtConfig.Alpha,
+		DisjointPaths: dhtConfig.DisjointPaths,
+		// Cheap enough to generate on start, but every ID costs an attacker
+		// about 256 keypairs.
+		Puzzles: PuzzlesConfig{Static: 8},
+		Timeouts: TimeoutsConfig{
+			RPC:       Duration(5 * time.Second),
+			Republish: Duration(dht.RepublishInterval),
+		},
+		Storage: StorageConfig{
+			Backend:  "memory",
+			MaxBytes: 256 << 20,
+			MaxKeys:  100000,
+			Eviction: string(dht.EvictFarthest),
+		},
+		API: APIConfig{Listen: "127.0.0.1:9080"},
+		Log: LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+// LoadConfig reads the config file at path on top of the defaults. The format
+// is picked from the extension: .json, .yaml, .yml or .toml.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&config)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&config)
+	case ".toml":
+		var metadata toml.MetaData
+		metadata, err = toml.Decode(string(data), &config)
+		if err == nil && len(metadata.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown field %q", metadata.Undecoded()[0].String())
+		}
+	default:
+		return config, fmt.Errorf("unsupported config file %q, expected .json, .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ApplyEnv overrides config with the KADEMLIA_* variables found by lookup,
+// which is normally os.LookupEnv. Lists such as KADEMLIA_BOOTSTRAP are comma separated.
+func (config *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	texts := map[string]*string{
+		"LISTEN":           &config.Listen,
+		"ADVERTISE":        &config.Advertise,
+		"DATA_DIR":         &config.DataDir,
+		"STORAGE_BACKEND":  &config.Storage.Backend,
+		"STORAGE_EVICTION": &config.Storage.Eviction,
+		"API_LISTEN":       &config.API.Listen,
+		"LOG_LEVEL":        &config.Log.Level,
+		"LOG_FORMAT":       &config.Log.Format,
+	}
+	for name, field := range texts {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			*field = value
+		}
+	}
+
+	ints := map[string]*int{
+		"K":                   &config.K,
+		"ALPHA":               &config.Alpha,
+		"DISJOINT_PATHS":      &config.DisjointPaths,
+		"STATIC_PUZZLE":       &config.Puzzles.Static,
+		"DYNAMIC_PUZZLE":      &config.Puzzles.Dynamic,
+		"BUCKET_IP_LIMIT":     &config.IPLimits.BucketIP,
+		"BUCKET_SUBNET_LIMIT": &config.IPLimits.BucketSubnet,
+		"TABLE_IP_LIMIT":      &config.IPLimits.TableIP,
+		"TABLE_SUBNET_LIMIT":  &config.IPLimits.TableSubnet,
+		"STORAGE_MAX_BYTES":   &config.Storage.MaxBytes,
+		"STORAGE_MAX_KEYS":    &config.Storage.MaxKeys,
+	}
+	for name, field := range ints {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s%s: %w", EnvPrefix, name, err)
+			}
+			*field = parsed
+		}
+	}
+
+	durations := map[string]*Duration{
+		"RPC_TIMEOUT":        &config.Timeouts.RPC,
+		"REPUBLISH_INTERVAL": &config.Timeouts.Republish,
+	}
+	for name, field := range durations {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			if err := field.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("invalid %s%s: %w", EnvPrefix, name, err)
+			}
+		}
+	}
+
+	if value, ok := lookup(EnvPrefix + "BOOTSTRAP"); ok {
+		config.Bootstrap = splitList(value)
+	}
+	return nil
+}
+
+// DHT returns the Kademlia parameters of the config
+func (config Config) DHT() dht.Config {
+	return dht.Config{
+		K:             config.K,
+		Alpha:         config.Alpha,
+		DisjointPaths: config.DisjointPaths,
+		StaticPuzzle:  config.Puzzles.Static,
+		DynamicPuzzle: config.Puzzles.Dynamic,
+
+		BucketIPLimit:     config.IPLimits.BucketIP,
+		BucketSubnetLimit: config.IPLimits.BucketSubnet,
+		TableIPLimit:      config.IPLimits.TableIP,
+		TableSubnetLimit:  config.IPLimits.TableSubnet,
+	}
+}
+
+// Quota returns the storage limits of the config
+func (config Config) Quota() dht.Quota {
+	return dht.Quota{
+		MaxBytes: config.Storage.MaxBytes,
+		MaxKeys:  config.Storage.MaxKeys,
+		Policy:   dht.EvictionPolicy(config.Storage.Eviction),
+	}
+}
+
+// AdvertiseAddress returns the address other nodes should use to reach the node
+func (config Config) AdvertiseAddress() string {
+	if config.Advertise != "" {
+		return config.Advertise
+	}
+	return config.Listen
+}
+
+// Validate reports whether the config can be used to start a node
+func (config Config) Validate() error {
+	if config.Listen == "" {
+		return fmt.Errorf("listen address must not be empty")
+	}
+	if err := config.DHT().Validate(); err != nil {
+		return err
+	}
+	if config.Timeouts.RPC <= 0 {
+		return fmt.Errorf("rpc timeout must be positive")
+	}
+	if config.Timeouts.Republish <= 0 {
+		return fmt.Errorf("republish interval must be positive")
+	}
+	if config.Storage.Backend != "memory" {
+		return fmt.Errorf("unknown storage backend %q, expected memory", config.Storage.Backend)
+	}
+	if err := config.Quota().Validate(); err != nil {
+		return err
+	}
+	if _, err := log.ParseLevel(config.Log.Level); err != nil {
+		return err
+	}
+	if config.Log.Format != "text" && config.Log.Format != "json" {
+		return fmt.Errorf("unknown log format %q, expected text or json", config.Log.Format)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,124 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"node.json": `{"listen": "0.0.0.0:4000", "bootstrap": ["a:4000", "b:4000"], "k": 8, "timeouts": {"rpc": "2s"}, "log": {"format": "json"}}`,
+		"node.yaml": "listen: 0.0.0.0:4000\nbootstrap: [a:4000, b:4000]\nk: 8\ntimeouts:\n  rpc: 2s\nlog:\n  format: json\n",
+		"node.toml": "listen = \"0.0.0.0:4000\"\nbootstrap = [\"a:4000\", \"b:4000\"]\nk = 8\n[timeouts]\nrpc = \"2s\"\n[log]\nformat = \"json\"\n",
+	}
+
+	expected := DefaultConfig()
+	expected.Listen = "0.0.0.0:4000"
+	expected.Bootstrap = []string{"a:4000", "b:4000"}
+	expected.K = 8
+	expected.Timeouts.RPC = Duration(2 * time.Second)
+	expected.Log.Format = "json"
+
+	for name, content := range files {
+		config, err := LoadConfig(writeConfig(t, name, content))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(config, expected) {
+			t.Errorf("%s: expected %+v but got %+v", name, expected, config)
+		}
+	}
+}
+
+func TestLoadConfigRejectsUnknownFields(t *testing.T) {
+	for name, content := range map[string]string{
+		"node.json": `{"lisen": "0.0.0.0:4000"}`,
+		"node.yaml": "lisen: 0.0.0.0:4000\n",
+		"node.toml": "lisen = \"0.0.0.0:4000\"\n",
+		"node.ini":  "listen = 0.0.0.0:4000\n",
+	} {
+		if _, err := LoadConfig(writeConfig(t, name, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"KADEMLIA_LISTEN":      "0.0.0.0:5000",
+		"KADEMLIA_BOOTSTRAP":   "kademlia-0:5000, kademlia-1:5000",
+		"KADEMLIA_ALPHA":       "5",
+		"KADEMLIA_RPC_TIMEOUT": "1500ms",
+		"KADEMLIA_API_LISTEN":  "",
+
+		"KADEMLIA_STORAGE_MAX_KEYS": "10",
+		"KADEMLIA_STORAGE_EVICTION": "lru",
+	}
+	config := DefaultConfig()
+	err := config.ApplyEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Listen != "0.0.0.0:5000" || config.Alpha != 5 || config.API.Listen != "" {
+		t.Errorf("Environment was not applied: %+v", config)
+	}
+	if !reflect.DeepEqual(config.Bootstrap, []string{"kademlia-0:5000", "kademlia-1:5000"}) {
+		t.Errorf("Unexpected bootstrap list %v", config.Bootstrap)
+	}
+	if config.Quota() != (dht.Quota{MaxBytes: DefaultConfig().Storage.MaxBytes, MaxKeys: 10, Policy: dht.EvictLeastRecentlyUsed}) {
+		t.Errorf("Unexpected storage quota %+v", config.Quota())
+	}
+	if config.Timeouts.RPC != Duration(1500*time.Millisecond) {
+		t.Errorf("Expected rpc timeout 1.5s but got %v", time.Duration(config.Timeouts.RPC))
+	}
+
+	err = config.ApplyEnv(func(name string) (string, bool) {
+		return "many", name == "KADEMLIA_K"
+	})
+	if err == nil {
+		t.Error("Expected an invalid KADEMLIA_K to be rejected")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("Expected the default config to be valid, got %v", err)
+	}
+
+	invalid := []func(*Config){
+		func(config *Config) { config.Listen = "" },
+		func(config *Config) { config.K = 0 },
+		func(config *Config) { config.Timeouts.RPC = 0 },
+		func(config *Config) { config.Storage.Backend = "disk" },
+		func(config *Config) { config.Storage.MaxBytes = -1 },
+		func(config *Config) { config.Storage.Eviction = "random" },
+		func(config *Config) { config.Log.Level = "loud" },
+		func(config *Config) { config.Log.Format = "xml" },
+	}
+	for i, change := range invalid {
+		config := DefaultConfig()
+		change(&config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("Case %d: expected %+v to be invalid", i, config)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// pkg/node/node.go
+package node
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/dht"
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
+)
+
+// keyFile is the file in the data directory holding the seed of the node's private key
+const keyFile = "node-key"
+
+// Node is a Kademlia node wired together from a Config
+type Node struct {
+	Config   Config
+	Contact  dht.Contact
+	Kademlia *dht.Kademlia
+	Network  *network.Network
+
+	stopRepublisher func()
+}
+
+// New binds a UDP socket on config.Listen and starts a node on it
+func New(config Config) (*Node, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	transport, err := network.NewUDPTransport(config.Listen)
+	if err != nil {
+		return nil, err
+	}
+	node, err := NewWithTransport(config, transport)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return node, nil
+}
+
+// NewWithTransport starts a node that communicates over transport
+func NewWithTransport(config Config, transport network.Transport) (*Node, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	identity, err := loadIdentity(config.DataDir, config.DHT())
+	if err != nil {
+		return nil, err
+	}
+	nodeID := identity.ID
+
+	me := dht.NewContact(nodeID, config.AdvertiseAddress())
+	rt := dht.NewRoutingTable(me, config.DHT())
+	store := dht.NewStore()
+	store.SetQuota(config.Quota(), nodeID)
+
+	net := network.NewNetwork(nodeID, rt, store, transport)
+	net.SetTimeout(time.Duration(config.Timeouts.RPC))
+	net.SetIdentity(identity)
+	net.Listen()
+
+	kademlia := dht.NewKademlia(rt, net, store, config.DHT())
+	kademlia.SetIdentity(identity)
+	return &Node{
+		Config:          config,
+		Contact:         me,
+		Kademlia:        kademlia,
+		Network:         net,
+		stopRepublisher: kademlia.StartRepublisher(time.Duration(config.Timeouts.Republish)),
+	}, nil
+}
+
+// Bootstrap joins the network through the given addresses and looks up the
+// node's own ID to fill the routing table. It fails only if none of them answer.
+func (node *Node) Bootstrap(addresses []string) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	joined := 0
+	for _, address := range addresses {
+		logger := log.WithFields(log.Fields{"subsystem": "node", "bootstrap": address})
+		// The ID is unknown until the bootstrap node answers the ping.
+		contact := dht.NewContact(dht.NewRandomKademliaID(), address)
+		if err := node.Network.Ping(&contact); err != nil {
+			logger.WithError(err).Warn("Failed to ping bootstrap node")
+			continue
+		}
+		logger.WithField("peer_id", contact.ID.String()).Info("Contacted bootstrap node")
+		node.Kademlia.RoutingTable.AddContact(contact, node.Network)
+		joined++
+	}
+	if joined == 0 {
+		return errors.New("no bootstrap node answered")
+	}
+
+	node.Kademlia.LookupContact(node.Contact.ID)
+	return nil
+}
+
+// Close stops the node
+func (node *Node) Close() error {
+	node.stopRepublisher()
+	return node.Network.Close()
+}
+
+// loadIdentity returns the identity whose key is kept in dataDir, creating
+// it on first use. Without a data directory every run gets a new identity.
+func loadIdentity(dataDir string, config dht.Config) (*dht.Identity, error) {
+	if dataDir == "" {
+		return dht.NewIdentity(config)
+	}
+
+	path := filepath.Join(dataDir, keyFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %s: %w", path, err)
+		}
+		identity, err := dht.NewIdentityFromSeed(seed, config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %s: %w", path, err)
+		}
+		return identity, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	identity, err := dht.NewIdentity(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, err
+	}
+	seed := hex.EncodeToString(identity.PrivateKey.Seed())
+	if err := os.WriteFile(path, []byte(seed+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xychen2001/d7024e-distributed-systems-team8/pkg/network"
+)
+
+func newTestNode(t *testing.T, mn *network.MemoryNetwork, config Config) *Node {
+	t.Helper()
+	transport, err := mn.Listen(config.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := NewWithTransport(config, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node
+}
+
+func TestBootstrapJoinsNetwork(t *testing.T) {
+	mn := network.NewMemoryNetwork()
+	config := DefaultConfig()
+	config.Timeouts.RPC = Duration(100 * time.Millisecond)
+	config.Listen = "node-a"
+	a := newTestNode(t, mn, config)
+	config.Listen = "node-b"
+	b := newTestNode(t, mn, config)
+
+	if err := b.Bootstrap([]string{"nowhere", "node-a"}); err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	contacts := b.Kademlia.RoutingTable.FindClosestContacts(a.Contact.ID, 1)
+	if len(contacts) != 1 || !contacts[0].ID.Equals(a.Contact.ID) {
+		t.Errorf("Expected node-a in the routing table of node-b, got %v", contacts)
+	}
+}
+
+func TestNodeKeepsIDInDataDir(t *testing.T) {
+	mn := network.NewMemoryNetwork()
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Listen = "first"
+	first := newTestNode(t, mn, config)
+	config.Listen = "second"
+	second := newTestNode(t, mn, config)
+
+	if !first.Contact.ID.Equals(second.Contact.ID) {
+		t.Errorf("Expected the ID to be reused, got %s and %s", first.Contact.ID, second.Contact.ID)
+	}
+}